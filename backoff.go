@@ -0,0 +1,101 @@
+package nestor
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	//BackoffFixed polls on a fixed interval
+	BackoffFixed string = "FIXED"
+	//BackoffExponential doubles the interval after every unsuccessful poll
+	BackoffExponential string = "EXPONENTIAL"
+	//BackoffJitter picks a random interval that grows with the previous one (decorrelated jitter)
+	BackoffJitter string = "JITTER"
+)
+
+//Backoff returns the delays between polls
+type Backoff interface {
+	// Next returns the delay before the next poll. previous is the delay before the last poll
+	// and zero before the first retry.
+	Next(previous time.Duration) time.Duration
+}
+
+//NewBackoff returns the backoff of a policy. interval is the base delay and max caps the
+// delays of EXPONENTIAL and JITTER policies; a zero max defaults to DefaultMaxBackoff.
+func NewBackoff(policy string, interval time.Duration, max time.Duration) (Backoff, error) {
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+	if max < interval {
+		max = interval
+	}
+	switch policy {
+	case BackoffFixed:
+		return NewFixedBackoff(interval), nil
+	case BackoffExponential:
+		return NewExponentialBackoff(interval, max), nil
+	case BackoffJitter:
+		return NewJitterBackoff(interval, max), nil
+	}
+	return nil, fmt.Errorf("found %s. expected %s, %s, %s", policy, BackoffFixed, BackoffExponential, BackoffJitter)
+}
+
+//NewFixedBackoff is constructor for a backoff that always waits interval
+func NewFixedBackoff(interval time.Duration) Backoff {
+	return &fixedBackoff{interval: interval}
+}
+
+//NewExponentialBackoff is constructor for a backoff that starts at initial and doubles up to max
+func NewExponentialBackoff(initial time.Duration, max time.Duration) Backoff {
+	return &exponentialBackoff{initial: initial, max: max}
+}
+
+//NewJitterBackoff is constructor for a decorrelated jitter backoff. Every delay is picked at random
+// between base and three times the previous delay, up to max, so that pollers started together drift apart.
+func NewJitterBackoff(base time.Duration, max time.Duration) Backoff {
+	return &jitterBackoff{base: base, max: max}
+}
+
+type fixedBackoff struct {
+	interval time.Duration
+}
+
+func (b *fixedBackoff) Next(previous time.Duration) time.Duration {
+	return b.interval
+}
+
+type exponentialBackoff struct {
+	initial time.Duration
+	max     time.Duration
+}
+
+func (b *exponentialBackoff) Next(previous time.Duration) time.Duration {
+	if previous <= 0 {
+		return b.initial
+	}
+	if previous >= b.max/2 {
+		return b.max
+	}
+	return previous * 2
+}
+
+type jitterBackoff struct {
+	base time.Duration
+	max  time.Duration
+}
+
+func (b *jitterBackoff) Next(previous time.Duration) time.Duration {
+	if previous < b.base {
+		previous = b.base
+	}
+	upper := b.max
+	if previous < b.max/3 {
+		upper = previous * 3
+	}
+	if upper <= b.base {
+		return b.base
+	}
+	return b.base + time.Duration(rand.Int63n(int64(upper-b.base)))
+}
@@ -0,0 +1,56 @@
+package nestor_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jerminb/nestor"
+)
+
+func TestFixedBackoff(t *testing.T) {
+	b := nestor.NewFixedBackoff(time.Second)
+	var delay time.Duration
+	for i := 0; i < 3; i++ {
+		if delay = b.Next(delay); delay != time.Second {
+			t.Fatalf("expected 1s. got %v", delay)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := nestor.NewExponentialBackoff(time.Second, 10*time.Second)
+	var delay time.Duration
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		if delay = b.Next(delay); delay != expected {
+			t.Fatalf("expected %v. got %v", expected, delay)
+		}
+	}
+}
+
+func TestJitterBackoff(t *testing.T) {
+	b := nestor.NewJitterBackoff(time.Second, 10*time.Second)
+	var delay time.Duration
+	for i := 0; i < 100; i++ {
+		previous := delay
+		if previous < time.Second {
+			previous = time.Second
+		}
+		delay = b.Next(delay)
+		if delay < time.Second || delay > 10*time.Second || delay > 3*previous {
+			t.Fatalf("expected a delay between 1s and min(10s, %v). got %v", 3*previous, delay)
+		}
+	}
+}
+
+func TestNewBackoff(t *testing.T) {
+	b, err := nestor.NewBackoff(nestor.BackoffExponential, 2*time.Minute, 0)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if d := b.Next(2 * time.Minute); d != 2*time.Minute {
+		t.Fatalf("expected the max to be raised to the interval. got %v", d)
+	}
+	if _, err := nestor.NewBackoff("LINEAR", time.Second, 0); err == nil {
+		t.Fatalf("expected error. got nil")
+	}
+}
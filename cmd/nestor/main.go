@@ -0,0 +1,260 @@
+//Command nestor parses, validates and executes nestor scripts.
+// Install it with go install github.com/jerminb/nestor/cmd/nestor.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/jerminb/nestor"
+	"github.com/jerminb/nestor/lexer"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// exitSuccess is returned when every statement succeeded
+	exitSuccess int = 0
+	// exitFailure is returned when a statement failed or was cancelled
+	exitFailure int = 1
+	// exitUsage is returned for invalid arguments, unreadable or invalid scripts
+	exitUsage int = 2
+)
+
+const usage = `usage: nestor <command> [flags] [script ...]
+
+commands:
+  run       parse, validate and execute a script
+  validate  parse and validate scripts without executing them
+  fmt       print scripts in canonical form
+
+A script argument of - reads the script from stdin.
+Scripts with SCHEDULE clauses run until nestor receives SIGINT or SIGTERM. Activations
+missed by a long run are skipped unless the clause ends with ON MISSED ONCE.
+The vault service used for ${vault:path#key} references and REFRESH is configured
+through VAULT_ADDR and VAULT_TOKEN. DOWNLOAD ... VERIFY SIGNATURE checks files against
+the ed25519 public key file given to run with -public-key. Downloads are cached across
+scripts in the directory given to run with -cache-dir.
+`
+
+//variableFlags collects repeated -var name=value flags
+type variableFlags map[string]string
+
+func (v variableFlags) String() string {
+	var str []string
+	for k, val := range v {
+		str = append(str, k+"="+val)
+	}
+	return strings.Join(str, ",")
+}
+
+func (v variableFlags) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected name=value. got %s", s)
+	}
+	v[parts[0]] = parts[1]
+	return nil
+}
+
+func main() {
+	os.Exit(runCommand(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+//runCommand dispatches a subcommand and returns the process exit code
+func runCommand(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	switch args[0] {
+	case "run":
+		return runScript(args[1:], stdin, stdout, stderr)
+	case "validate":
+		return validateScripts(args[1:], stdin, stdout, stderr)
+	case "fmt":
+		return formatScripts(args[1:], stdin, stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitSuccess
+	default:
+		fmt.Fprintf(stderr, "unknown command %s\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+func runScript(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	vars := make(variableFlags)
+	fs.Var(vars, "var", "set a variable as name=value, overriding SET statements and the environment")
+	verbose := fs.Bool("v", false, "log debug messages")
+	publicKey := fs.String("public-key", "", "ed25519 public key file that downloaded files are verified against")
+	cacheDir := fs.String("cache-dir", "", "directory downloads are cached in across scripts")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: nestor run [-var name=value] [-public-key file] [-cache-dir dir] [-v] script")
+		return exitUsage
+	}
+	log.SetOutput(stderr)
+	if *verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+	q, err := parseScript(fs.Arg(0), stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(0), err)
+		return exitUsage
+	}
+	if err := nestor.Validate(q); err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(0), err)
+		return exitUsage
+	}
+	if err := configureVault(); err != nil {
+		fmt.Fprintf(stderr, "vault: %v\n", err)
+		return exitUsage
+	}
+	if err := configureSignatureKey(*publicKey); err != nil {
+		fmt.Fprintf(stderr, "public key: %v\n", err)
+		return exitUsage
+	}
+	nestor.SetDownloadCacheDir(*cacheDir)
+	ctx, cancel := nestor.WithTerminationSignals(context.Background())
+	defer cancel()
+	results, err := nestor.NewQueryExecutorWithVariables(vars).ExecuteQueryWithContext(ctx, q)
+	for _, r := range results {
+		fmt.Fprintf(stdout, "%-9s %-12v %s\n", r.Status, r.Duration, r.Statement.String())
+		if p, ok := r.Payload.(*nestor.PollerPayload); ok && len(p.Endpoints) > 1 {
+			for _, e := range p.Endpoints {
+				fmt.Fprintf(stdout, "  %-9s %-12v %s\n", e.Status, e.Duration, e.URL)
+			}
+		}
+		if p, ok := r.Payload.(*nestor.DownloaderPayload); ok && p.URL != "" {
+			if d, ok := r.Statement.(*lexer.DownloadStatement); ok && len(d.Mirrors) > 0 {
+				fmt.Fprintf(stdout, "  served by %s\n", p.URL)
+			}
+		}
+		if p, ok := r.Payload.(*nestor.DownloadBatchPayload); ok {
+			for _, f := range p.Files {
+				fmt.Fprintf(stdout, "  %-9s %-12v %s\n", f.Status, f.Duration, f.URL)
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", fs.Arg(0), err)
+		if len(results) == 0 {
+			// nothing was started; variables could not be resolved
+			return exitUsage
+		}
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+func validateScripts(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: nestor validate script ...")
+		return exitUsage
+	}
+	code := exitSuccess
+	for _, filename := range args {
+		q, err := parseScript(filename, stdin)
+		if err == nil {
+			err = nestor.Validate(q)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", filename, err)
+			code = exitUsage
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: ok\n", filename)
+	}
+	return code
+}
+
+func formatScripts(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	write := fs.Bool("w", false, "write the result to the script instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(stderr, "usage: nestor fmt [-w] script ...")
+		return exitUsage
+	}
+	code := exitSuccess
+	for _, filename := range fs.Args() {
+		q, err := parseScript(filename, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", filename, err)
+			code = exitUsage
+			continue
+		}
+		formatted := formatQuery(q)
+		if !*write || filename == "-" {
+			fmt.Fprint(stdout, formatted)
+			continue
+		}
+		if err := ioutil.WriteFile(filename, []byte(formatted), 0644); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", filename, err)
+			code = exitUsage
+		}
+	}
+	return code
+}
+
+//formatQuery prints a script in canonical form, one statement per line
+func formatQuery(q *lexer.Query) string {
+	if len(q.Statements) == 0 {
+		return ""
+	}
+	return q.String() + "\n"
+}
+
+func parseScript(filename string, stdin io.Reader) (*lexer.Query, error) {
+	r := stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return lexer.NewParser(r).ParseQuery()
+}
+
+func configureVault() error {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return nil
+	}
+	vs, err := nestor.NewVaultService(addr, token)
+	if err != nil {
+		return err
+	}
+	nestor.SetVaultService(vs)
+	return nil
+}
+
+func configureSignatureKey(filename string) error {
+	if filename == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	key, err := nestor.ParseSignatureKey(data)
+	if err != nil {
+		return err
+	}
+	nestor.SetSignatureKey(key)
+	return nil
+}
@@ -2,10 +2,11 @@ package nestor
 
 import (
 	"bufio"
+	"context"
 	"database/sql"
+	"fmt"
 	"io"
 	"os"
-	"reflect"
 	"regexp"
 )
 
@@ -14,6 +15,11 @@ type Execer interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
 }
 
+// ExecerContext is an interface used by ExecContext.
+type ExecerContext interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
 //DatabaserResult is an encapsulation of possible outcomes of a
 //db.Exec to allow for multiple command execution
 type DatabaserResult struct {
@@ -21,22 +27,44 @@ type DatabaserResult struct {
 	Error     error
 }
 
+//DatabaserParameters are the parameters of Databaser's Execute
+type DatabaserParameters struct {
+	FilePath        string
+	SectionRegex    string
+	NamedQueryRegex string
+	DB              *sql.DB
+}
+
+//DatabaserPayload is the payload of an applied sql file
+type DatabaserPayload struct {
+	Results []DatabaserResult
+	// RowsAffected is the sum of the rows affected by every successful query
+	RowsAffected int64
+}
+
 //Databaser applies content of a file to a given database.
 // To make the class as generic as possible, sql db is injected.
 // The implementation of inverted logic can be found in SQLDBFactory.
 type Databaser struct {
 	queries map[string]string
+	names   []string
 }
 
 //ApplyWithSection applies one or more sections of a file to a db.
 //regex is to allow for annotation and selevtive application like:
 // -- name: insert-profile -> Exec(insert-profile)
 func (d *Databaser) ApplyWithSection(filepath string, regex string, namedQueryRegex string, db *sql.DB) ([]DatabaserResult, error) {
+	return d.ApplyWithSectionContext(context.Background(), filepath, regex, namedQueryRegex, db)
+}
+
+//ApplyWithSectionContext is ApplyWithSection that cancels in-flight queries when ctx is cancelled.
+// Sections that have not been started when ctx is cancelled are skipped.
+func (d *Databaser) ApplyWithSectionContext(ctx context.Context, filepath string, regex string, namedQueryRegex string, db *sql.DB) ([]DatabaserResult, error) {
 	err := d.LoadFromFile(filepath, namedQueryRegex)
 	if err != nil {
 		return nil, err
 	}
-	return d.Exec(db, regex)
+	return d.ExecContext(ctx, db, regex)
 }
 
 // Load imports sql queries from any io.Reader.
@@ -45,6 +73,7 @@ func (d *Databaser) Load(r io.Reader, namedQueryRegex string) error {
 	queries := scanner.Run(bufio.NewScanner(r))
 
 	d.queries = queries
+	d.names = scanner.Names()
 
 	return nil
 }
@@ -66,8 +95,8 @@ func (d *Databaser) lookupQuery(name string) (query []string, err error) {
 		return nil, err
 	}
 	query = make([]string, 0, len(d.queries))
-	for _, v := range d.queries {
-		if r.MatchString(v) {
+	for _, n := range d.names {
+		if v := d.queries[n]; r.MatchString(v) {
 			query = append(query, v)
 		}
 	}
@@ -91,9 +120,61 @@ func (d *Databaser) Exec(db Execer, name string) ([]DatabaserResult, error) {
 	return result, nil
 }
 
-//Execute executes Databaser's ApplyWithSection to implement Executable interface
-func (d *Databaser) Execute(params ...interface{}) (result []reflect.Value, err error) {
-	return execute(d.ApplyWithSection, params...)
+// ExecContext is Exec that passes ctx to the database and stops at cancellation.
+func (d *Databaser) ExecContext(ctx context.Context, db ExecerContext, name string) ([]DatabaserResult, error) {
+	query, err := d.lookupQuery(name)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]DatabaserResult, 0)
+	for _, q := range query {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		r, e := db.ExecContext(ctx, q)
+		result = append(result, DatabaserResult{
+			SQLResult: r,
+			Error:     e,
+		})
+	}
+	return result, nil
+}
+
+//Execute executes Databaser's ApplyWithSectionContext with typed parameters. The error of the failed
+// queries is returned along with the payload.
+func (d *Databaser) Execute(ctx context.Context, params DatabaserParameters) (*DatabaserPayload, error) {
+	results, err := d.ApplyWithSectionContext(ctx, params.FilePath, params.SectionRegex, params.NamedQueryRegex, params.DB)
+	payload := &DatabaserPayload{
+		Results: results,
+	}
+	var failed []error
+	for _, r := range results {
+		if r.Error != nil {
+			failed = append(failed, r.Error)
+			continue
+		}
+		if r.SQLResult == nil {
+			continue
+		}
+		if n, err := r.SQLResult.RowsAffected(); err == nil {
+			payload.RowsAffected += n
+		}
+	}
+	if err == nil {
+		err = queriesError(failed, len(results))
+	}
+	return payload, err
+}
+
+// queriesError returns the error of the failed queries of a sql file
+func queriesError(failed []error, total int) error {
+	switch {
+	case len(failed) == 1:
+		return failed[0]
+	case len(failed) > 1:
+		return fmt.Errorf("%d of %d queries failed. first: %v", len(failed), total, failed[0])
+	}
+	return nil
 }
 
 //NewDatabaser is the constructor Databaser class with IoC
@@ -11,6 +11,7 @@ type DatabaserScanner struct {
 	regex   string
 	line    string
 	queries map[string]string
+	names   []string
 	current string
 }
 
@@ -44,7 +45,7 @@ func queryState(s *DatabaserScanner) stateFn {
 }
 
 func (s *DatabaserScanner) appendQueryLine() {
-	current := s.queries[s.current]
+	current, ok := s.queries[s.current]
 	line := strings.Trim(s.line, " \t")
 	reg := regexp.MustCompile(`^-* *$`)
 	line = reg.ReplaceAllString(line, "${2}")
@@ -52,6 +53,9 @@ func (s *DatabaserScanner) appendQueryLine() {
 		return
 	}
 
+	if !ok {
+		s.names = append(s.names, s.current)
+	}
 	if len(current) > 0 {
 		current = current + "\n"
 	}
@@ -62,6 +66,7 @@ func (s *DatabaserScanner) appendQueryLine() {
 //Run iterates through a bufio scanner and finds named queires
 func (s *DatabaserScanner) Run(io *bufio.Scanner) map[string]string {
 	s.queries = make(map[string]string)
+	s.names = make([]string, 0)
 
 	for state := initialState; io.Scan(); {
 		s.line = io.Text()
@@ -71,6 +76,11 @@ func (s *DatabaserScanner) Run(io *bufio.Scanner) map[string]string {
 	return s.queries
 }
 
+//Names returns the names of the queries found by Run in the order they appear in the file
+func (s *DatabaserScanner) Names() []string {
+	return s.names
+}
+
 //NewDtabaserScanner is constructor for DtabaserScanner class
 func NewDtabaserScanner(regex string) *DatabaserScanner {
 	return &DatabaserScanner{
@@ -1,6 +1,7 @@
 package nestor_test
 
 import (
+	"context"
 	"testing"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
@@ -57,14 +58,39 @@ func TestExecute_Databaser(t *testing.T) {
 	mock.ExpectExec("INSERT INTO eda_sp_permission;").WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectExec("INSERT INTO eda_sp_perm;").WillReturnResult(sqlmock.NewResult(2, 2))
 
-	result, err := dber.Execute("assets/databaser_test_regex.sql", "(.*)", "^\\s*--\\s*Data for Name:\\s*(\\S+);", db)
+	result, err := dber.Execute(context.Background(), nestor.DatabaserParameters{
+		FilePath:        "assets/databaser_test_regex.sql",
+		SectionRegex:    "(.*)",
+		NamedQueryRegex: "^\\s*--\\s*Data for Name:\\s*(\\S+);",
+		DB:              db,
+	})
 	if err != nil {
 		t.Fatalf("expected nil. got %v", err)
 	}
 	if result == nil {
 		t.Fatalf("expected [1,1]. got nil")
 	}
-	if len(result) != 2 {
-		t.Fatalf("expected two result. got %d", len(result))
+	if len(result.Results) != 2 {
+		t.Fatalf("expected two result. got %d", len(result.Results))
+	}
+	if result.RowsAffected != 3 {
+		t.Fatalf("expected 3 rows affected. got %d", result.RowsAffected)
+	}
+}
+
+func TestApplyCancelled(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	dber := nestor.NewDatabaser()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := dber.ApplyWithSectionContext(ctx, "assets/databaser_test_regex.sql", "(.*)", "^\\s*--\\s*Data for Name:\\s*(\\S+);", db)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled. got %v", err)
+	}
+	if len(result) != 0 {
+		t.Fatalf("expected no result. got %d", len(result))
 	}
 }
@@ -0,0 +1,185 @@
+package nestor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// metadataSuffix is appended to the name of a downloaded file to name its metadata file
+	metadataSuffix = ".nestor.json"
+
+	cacheBlobDir = "sha256"
+	cacheURLDir  = "urls"
+)
+
+var defaultDownloadCacheDir string
+
+//SetDownloadCacheDir sets the cache directory of the downloaders of statements. An empty dir disables the cache.
+func SetDownloadCacheDir(dir string) {
+	defaultDownloadCacheDir = dir
+}
+
+// downloadMetadata is kept next to a downloaded file so the next download of the same url can be
+// conditional on the validators of the server. SHA256 is the digest of the file as it was downloaded.
+// The url is only kept as its digest, URLSHA256, since it may hold resolved secrets.
+type downloadMetadata struct {
+	URLSHA256    string `json:"url_sha256"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	SHA256       string `json:"sha256"`
+}
+
+func newDownloadMetadata(url string, header http.Header) *downloadMetadata {
+	return &downloadMetadata{
+		URLSHA256:    urlSHA256(url),
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	}
+}
+
+func urlSHA256(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+// hasValidators reports whether a request can be made conditional on m
+func (m *downloadMetadata) hasValidators() bool {
+	return m.ETag != "" || m.LastModified != ""
+}
+
+// setConditions makes req conditional on the validators of m
+func (m *downloadMetadata) setConditions(req *http.Request) {
+	if m.ETag != "" {
+		req.Header.Set("If-None-Match", m.ETag)
+	}
+	if m.LastModified != "" {
+		req.Header.Set("If-Modified-Since", m.LastModified)
+	}
+}
+
+func readMetadata(filename string) (*downloadMetadata, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m := &downloadMetadata{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func writeMetadata(filename string, m *downloadMetadata) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, string(b), 0644)
+}
+
+// localMetadata returns the metadata of the file downloaded from url to filename, or nil if the file
+// was downloaded from another url or changed since
+func localMetadata(filename string, url string) *downloadMetadata {
+	m, err := readMetadata(filename + metadataSuffix)
+	if err != nil || m.URLSHA256 != urlSHA256(url) {
+		return nil
+	}
+	if sum, err := fileSHA256(filename); err != nil || sum != m.SHA256 {
+		return nil
+	}
+	return m
+}
+
+func fileSHA256(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyFile copies src to dst through a temporary file so readers never see a partial file
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, in); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
+
+// downloadCache is a content addressable store of downloaded files shared by all downloads.
+// Files are stored under sha256/<digest> and the metadata of the last download of a url under
+// urls/<digest of the url>.json.
+type downloadCache struct {
+	dir string
+}
+
+func (c *downloadCache) blobPath(sum string) string {
+	return filepath.Join(c.dir, cacheBlobDir, sum)
+}
+
+// urlPath returns the path of the metadata of the url with the sha256 digest sum
+func (c *downloadCache) urlPath(sum string) string {
+	return filepath.Join(c.dir, cacheURLDir, sum+".json")
+}
+
+// has reports whether the file with the sha256 digest sum is in the cache
+func (c *downloadCache) has(sum string) bool {
+	_, err := os.Stat(c.blobPath(sum))
+	return err == nil
+}
+
+// lookup returns the metadata of the cached file last downloaded from url
+func (c *downloadCache) lookup(url string) *downloadMetadata {
+	sum := urlSHA256(url)
+	m, err := readMetadata(c.urlPath(sum))
+	if err != nil || m.URLSHA256 != sum || !c.has(m.SHA256) {
+		return nil
+	}
+	return m
+}
+
+// store adds filename, downloaded with metadata m, to the cache
+func (c *downloadCache) store(filename string, m *downloadMetadata) error {
+	if !c.has(m.SHA256) {
+		if err := copyFile(filename, c.blobPath(m.SHA256)); err != nil {
+			return err
+		}
+	}
+	return writeMetadata(c.urlPath(m.URLSHA256), m)
+}
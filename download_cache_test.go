@@ -0,0 +1,221 @@
+package nestor_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jerminb/nestor"
+	"github.com/jerminb/nestor/testserver"
+)
+
+// artifactServer serves content with an ETag and counts the requests transferring it
+type artifactServer struct {
+	mu      sync.Mutex
+	content []byte
+	etag    string
+	gets    int
+}
+
+func (s *artifactServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	content, etag := s.content, s.etag
+	if r.Method == "GET" {
+		s.gets++
+	}
+	s.mu.Unlock()
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "artifact", time.Time{}, bytes.NewReader(content))
+}
+
+func (s *artifactServer) set(content string, etag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.content, s.etag = []byte(content), etag
+}
+
+func (s *artifactServer) transfers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gets
+}
+
+func executeDownload(t *testing.T, d *nestor.Downloader, params nestor.DownloaderParameters) *nestor.DownloaderPayload {
+	res, err := d.Execute(context.Background(), params)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	return res
+}
+
+func checkContent(t *testing.T, filename string, expected string) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil || string(b) != expected {
+		t.Fatalf("expected %s in %s. got %s %v", expected, filename, b, err)
+	}
+}
+
+func TestExecute_DownloaderLastModified(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "file")
+	testserver.WithTestServer(t, func(url string) {
+		d := nestor.NewDownloader()
+		params := nestor.DownloaderParameters{FilePath: filename, URL: url}
+		res := executeDownload(t, d, params)
+		if res.NotModified || res.BytesDownloaded != 1024 {
+			t.Fatalf("expected 1024 bytes transferred. got %d %v", res.BytesDownloaded, res.NotModified)
+		}
+		if _, err := os.Stat(filename + ".nestor.json"); err != nil {
+			t.Fatalf("expected metadata next to %s. got %v", filename, err)
+		}
+		if res = executeDownload(t, d, params); !res.NotModified || res.BytesDownloaded != 0 {
+			t.Fatalf("expected file not to be transferred. got %d %v", res.BytesDownloaded, res.NotModified)
+		}
+		// a local change of the same size is transferred again
+		if err := ioutil.WriteFile(filename, make([]byte, 1024), 0644); err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		if res = executeDownload(t, d, params); res.NotModified || res.BytesDownloaded != 1024 {
+			t.Fatalf("expected 1024 bytes transferred. got %d %v", res.BytesDownloaded, res.NotModified)
+		}
+		if b, err := ioutil.ReadFile(filename); err != nil || b[1] != 1 {
+			t.Fatalf("expected the content of the server. got %v", err)
+		}
+	}, testserver.ContentLength(1024), testserver.LastModified(time.Now().Add(-time.Hour)))
+}
+
+func TestExecute_DownloaderETag(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "file")
+	as := &artifactServer{}
+	as.set("version 1", `"v1"`)
+	s := httptest.NewServer(as)
+	defer s.Close()
+	d := nestor.NewDownloader()
+	params := nestor.DownloaderParameters{FilePath: filename, URL: s.URL}
+	executeDownload(t, d, params)
+	if res := executeDownload(t, d, params); !res.NotModified || as.transfers() != 1 {
+		t.Fatalf("expected a single transfer. got %d", as.transfers())
+	}
+	// same size, new version
+	as.set("version 2", `"v2"`)
+	if res := executeDownload(t, d, params); res.NotModified || as.transfers() != 2 {
+		t.Fatalf("expected a second transfer. got %d", as.transfers())
+	}
+	checkContent(t, filename, "version 2")
+}
+
+func TestExecute_DownloaderCache(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+	as := &artifactServer{}
+	as.set("cached content", `"v1"`)
+	s := httptest.NewServer(as)
+	d := nestor.NewDownloader()
+	d.CacheDir = cacheDir
+	executeDownload(t, d, nestor.DownloaderParameters{FilePath: filepath.Join(dir, "a"), URL: s.URL})
+	sum := sha256.Sum256([]byte("cached content"))
+	if _, err := os.Stat(filepath.Join(cacheDir, "sha256", hex.EncodeToString(sum[:]))); err != nil {
+		t.Fatalf("expected file in cache. got %v", err)
+	}
+	// another destination of the same url is copied from the cache once the server confirms it is unchanged
+	res := executeDownload(t, d, nestor.DownloaderParameters{FilePath: filepath.Join(dir, "b"), URL: s.URL})
+	if !res.NotModified || as.transfers() != 1 {
+		t.Fatalf("expected a copy from the cache. got %v %d", res.NotModified, as.transfers())
+	}
+	checkContent(t, filepath.Join(dir, "b"), "cached content")
+	// a known checksum is looked up without asking the server
+	s.Close()
+	res = executeDownload(t, d, nestor.DownloaderParameters{
+		FilePath: filepath.Join(dir, "c"),
+		URL:      s.URL + "/elsewhere",
+		Checksum: &nestor.Checksum{Algorithm: nestor.ChecksumSHA256, Sum: sum[:]},
+	})
+	if !res.NotModified {
+		t.Fatalf("expected a copy from the cache. got a transfer")
+	}
+	checkContent(t, filepath.Join(dir, "c"), "cached content")
+}
+
+func TestSetDownloadCacheDir(t *testing.T) {
+	nestor.SetDownloadCacheDir("/var/cache/nestor")
+	defer nestor.SetDownloadCacheDir("")
+	if d := nestor.NewDownloader(); d.CacheDir != "/var/cache/nestor" {
+		t.Fatalf("expected /var/cache/nestor. got %s", d.CacheDir)
+	}
+}
+
+func TestExecute_DownloaderMismatchRemovesMetadata(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "file")
+	as := &artifactServer{}
+	as.set("version 1", `"v1"`)
+	s := httptest.NewServer(as)
+	defer s.Close()
+	d := nestor.NewDownloader()
+	checkRemoved := func() {
+		for _, f := range []string{filename, filename + ".nestor.json"} {
+			if _, err := os.Stat(f); !os.IsNotExist(err) {
+				t.Fatalf("expected %s to be removed. got %v", f, err)
+			}
+		}
+	}
+	wrong := &nestor.Checksum{Algorithm: nestor.ChecksumSHA512, Sum: make([]byte, 64)}
+
+	// an unchanged file failing verification
+	executeDownload(t, d, nestor.DownloaderParameters{FilePath: filename, URL: s.URL})
+	if _, err := d.Execute(context.Background(), nestor.DownloaderParameters{FilePath: filename, URL: s.URL, Checksum: wrong}); err != nestor.ErrBadChecksum {
+		t.Fatalf("expected %v. got %v", nestor.ErrBadChecksum, err)
+	}
+	checkRemoved()
+
+	// a transferred file failing verification
+	executeDownload(t, d, nestor.DownloaderParameters{FilePath: filename, URL: s.URL})
+	as.set("version 2", `"v2"`)
+	if _, err := d.Execute(context.Background(), nestor.DownloaderParameters{FilePath: filename, URL: s.URL, Checksum: wrong}); err != nestor.ErrBadChecksum {
+		t.Fatalf("expected %v. got %v", nestor.ErrBadChecksum, err)
+	}
+	checkRemoved()
+}
+
+func TestExecute_DownloaderMetadataOmitsURL(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	as := &artifactServer{}
+	as.set("version 1", `"v1"`)
+	s := httptest.NewServer(as)
+	defer s.Close()
+	d := nestor.NewDownloader()
+	d.CacheDir = filepath.Join(dir, "cache")
+	params := nestor.DownloaderParameters{FilePath: filepath.Join(dir, "file"), URL: s.URL + "/?token=s3cr3t"}
+	executeDownload(t, d, params)
+	if res := executeDownload(t, d, params); !res.NotModified {
+		t.Fatalf("expected file not to be transferred. got %d bytes", res.BytesDownloaded)
+	}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err == nil && bytes.Contains(b, []byte("s3cr3t")) {
+			t.Fatalf("expected the url not to be written. got %s in %s", b, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+}
@@ -1,9 +1,18 @@
 package nestor
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"io/ioutil"
+	"math"
+	"net/http"
+	neturl "net/url"
 	"os"
-	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cavaliercoder/grab"
@@ -16,24 +25,108 @@ import (
 // Hook functions are called synchronously and should never block unnecessarily.
 type Hook func(string) error
 
+//DownloaderParameters are the parameters of Downloader's Execute.
+// Header is sent along with the Downloader's Header, including to the checksum and signature urls on
+// the host of URL.
+// A download failing its Checksum or the signature fetched from SignatureURL is deleted.
+// A verified download is extracted into ExtractTo when set, see Extract. Mirrors serve the same file
+// as URL and are tried when it fails, see Execute. Neither header is sent to the Mirrors on another
+// host than URL.
+type DownloaderParameters struct {
+	FilePath        string
+	URL             string
+	Header          http.Header
+	Checksum        *Checksum
+	SignatureURL    string
+	ExtractTo       string
+	StripComponents int
+	Mirrors         []string
+	FastestFirst    bool
+}
+
+//DownloaderPayload is the payload of a completed download. NotModified is set when the file was
+// unchanged and not transferred. ExtractedFiles are the paths of the extracted files relative to the
+// extraction directory. URL is the url, or mirror, which served the file.
+type DownloaderPayload struct {
+	Filename        string
+	URL             string
+	BytesDownloaded int64
+	NotModified     bool
+	ExtractedFiles  []string
+}
+
+//DownloadBatchParameters are the parameters of Downloader's ExecuteBatch. The files of URLs, and of the
+// urls listed in the Manifest file, one per line, are saved into Directory under the names given by
+// the servers. Header is sent with every request. A failing download cancels the others unless
+// ContinueOnError is set.
+type DownloadBatchParameters struct {
+	Directory       string
+	URLs            []string
+	Manifest        string
+	Header          http.Header
+	ContinueOnError bool
+}
+
+//DownloadBatchPayload is the payload of a batch download. Files are the outcomes of its urls in order.
+type DownloadBatchPayload struct {
+	Files []*FileStatus
+}
+
+//FileStatus is the outcome of downloading one file of a batch. Downloads cancelled by the failure of
+// another one are StatusCancelled.
+type FileStatus struct {
+	URL             string
+	Filename        string
+	Status          Status
+	BytesDownloaded int64
+	Duration        time.Duration
+	Error           error
+}
+
 //Downloader is implemented to manage file download of different sized.
 //The goal is to make sure that connectivity, resume and authentication are all
-// encapsulated in a single implementation
+// encapsulated in a single implementation. Header is sent with every request to the host of a
+// download, e.g. credentials of an artifact repository. CacheDir is the directory of the cache shared by Execute across scripts,
+// see SetDownloadCacheDir.
 type Downloader struct {
 	client          *grab.Client
 	UpdateTicker    int
 	BatchWorkerSize int
+	Header          http.Header
+	CacheDir        string
 }
 
 //Download uses a threaded download approach to improve speed and exception handling.
 func (d *Downloader) Download(filepath string, url string) error {
-	req, err := grab.NewRequest(filepath, url)
+	return d.DownloadWithContext(context.Background(), filepath, url)
+}
+
+//DownloadWithContext is Download that aborts the transfer when ctx is cancelled
+func (d *Downloader) DownloadWithContext(ctx context.Context, filepath string, url string) error {
+	req, err := d.newRequest(ctx, filepath, url, nil)
 	if err != nil {
 		return err
 	}
+	_, err = d.download(req)
+	return err
+}
+
+// newRequest returns the request of a download sending the Downloader's Header and header
+func (d *Downloader) newRequest(ctx context.Context, filepath string, url string, header http.Header) (*grab.Request, error) {
+	req, err := grab.NewRequest(filepath, url)
+	if err != nil {
+		return nil, err
+	}
+	setRequestHeader(req.HTTPRequest, d.Header, header)
+	return req.WithContext(ctx), nil
+}
+
+func (d *Downloader) download(req *grab.Request) (*grab.Response, error) {
 	log.Debugf("Downloading %v ...", req.URL())
 	resp := d.client.Do(req)
-	log.Debugf("%v", resp.HTTPResponse.Status)
+	if resp.HTTPResponse != nil {
+		log.Debugf("%v", resp.HTTPResponse.Status)
+	}
 	t := time.NewTicker(time.Duration(d.UpdateTicker) * time.Millisecond)
 	defer t.Stop()
 
@@ -54,98 +147,454 @@ Loop:
 
 	// check for errors
 	if err := resp.Err(); err != nil {
-		return err
+		return resp, err
 	}
 
 	log.Debugf("Download saved to ./%v", resp.Filename)
 
-	return nil
+	return resp, nil
 }
 
 //DownloadBatch sends multiple HTTP requests and downloads the content of the
 // requested URLs to the given destination directory
 func (d *Downloader) DownloadBatch(filepath string, hook Hook, urls ...string) error {
-	fi, err := os.Stat(filepath)
+	return d.DownloadBatchWithContext(context.Background(), filepath, hook, urls...)
+}
+
+//DownloadBatchWithContext is DownloadBatch that aborts all transfers when ctx is cancelled.
+// The first failing download cancels the others and its error is returned.
+func (d *Downloader) DownloadBatchWithContext(ctx context.Context, filepath string, hook Hook, urls ...string) error {
+	files, err := d.downloadBatch(ctx, filepath, hook, nil, false, urls)
 	if err != nil {
 		return err
 	}
+	return batchError(ctx, files)
+}
+
+// downloadBatch downloads urls into the directory dir with BatchWorkerSize concurrent workers and
+// returns the outcome of every url. Unless continueOnError is set, the first failing download cancels
+// the others. It returns once every worker has stopped.
+func (d *Downloader) downloadBatch(ctx context.Context, dir string, hook Hook, header http.Header, continueOnError bool, urls []string) ([]*FileStatus, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
 	if !fi.IsDir() {
-		return fmt.Errorf("destination is not a directory")
+		return nil, fmt.Errorf("destination is not a directory")
 	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	reqs := make([]*grab.Request, len(urls))
+	index := make(map[*grab.Request]int, len(urls))
 	for i := 0; i < len(urls); i++ {
-		req, err := grab.NewRequest(filepath, urls[i])
+		req, err := d.newRequest(ctx, dir, urls[i], header)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if hook != nil {
 			req.AfterCopy = getGrabHookFromHook(hook)
 		}
 		reqs[i] = req
+		index[req] = i
 	}
-	responses := d.client.DoBatch(d.BatchWorkerSize, reqs...)
-
-Loop:
-	for i := 0; i < len(reqs); {
-		select {
-		case resp := <-responses:
-			log.Debugf("Checking response for filename /%s", resp.Filename)
-			if resp == nil {
-				log.Debugf("Response is nil breaking the download loop")
-				break Loop
-			}
-			err = d.isComplete(resp)
-			if err != nil {
-				return fmt.Errorf("%s: %v", resp.Filename, err)
-			}
-			if err := resp.Err(); err != nil {
-				return fmt.Errorf("%s: %v", resp.Filename, err)
+	files := make([]*FileStatus, len(urls))
+	for resp := range d.client.DoBatch(d.BatchWorkerSize, reqs...) {
+		<-resp.Done
+		i := index[resp.Request]
+		f := &FileStatus{
+			URL:             urls[i],
+			Filename:        resp.Filename,
+			Status:          StatusSucceeded,
+			BytesDownloaded: resp.BytesComplete(),
+			Duration:        resp.Duration(),
+			Error:           resp.Err(),
+		}
+		switch {
+		case f.Error == nil:
+			log.Debugf("Download of %s saved to %s", f.URL, f.Filename)
+		// downloads stopped by the cancellation of ctx are neither succeeded nor failed
+		case ctx.Err() != nil:
+			f.Status = StatusCancelled
+		default:
+			f.Status = StatusFailed
+			log.Warnf("Downloading %s failed: %v", f.URL, f.Error)
+			if !continueOnError {
+				cancel()
 			}
-			i++
+		}
+		files[i] = f
+	}
+	return files, nil
+}
+
+// batchError returns the error of the failed downloads of a batch, or the error of ctx if downloads
+// were cancelled
+func batchError(ctx context.Context, files []*FileStatus) error {
+	var failed []*FileStatus
+	cancelled := false
+	for _, f := range files {
+		switch f.Status {
+		case StatusFailed:
+			failed = append(failed, f)
+		case StatusCancelled:
+			cancelled = true
+		}
+	}
+	switch {
+	case len(failed) == 1:
+		return fmt.Errorf("%s: %v", failed[0].URL, failed[0].Error)
+	case len(failed) > 1:
+		return fmt.Errorf("%d of %d downloads failed. first %s: %v", len(failed), len(files), failed[0].URL, failed[0].Error)
+	case cancelled && ctx.Err() != nil:
+		return ctx.Err()
+	}
+	return nil
+}
+
+func getGrabHookFromHook(hook Hook) grab.Hook {
+	if hook != nil {
+		return func(resp *grab.Response) error {
+			return hook(resp.Filename)
 		}
 	}
 	return nil
 }
 
-func (d *Downloader) isComplete(resp *grab.Response) error {
-	<-resp.Done
-	if !resp.IsComplete() {
-		return fmt.Errorf("Response.IsComplete returned false")
+//Execute executes Downloader's DownloadWithContext with typed parameters. Unchanged files are not
+// transferred again, see fetch. The metadata of a verified download is kept in a file next to it
+// named after it with a .nestor.json suffix, and the download is added to the cache when CacheDir is set.
+// When URL fails, either to answer, with a non-2xx status or with a file failing verification, the
+// Mirrors are tried in order. With FastestFirst, URL and the Mirrors are tried in the order of their
+// response time to a HEAD request instead. The payload records the URL which served the file.
+func (d *Downloader) Execute(ctx context.Context, params DownloaderParameters) (*DownloaderPayload, error) {
+	req, err := d.newRequest(ctx, params.FilePath, params.URL, params.Header)
+	if err != nil {
+		return nil, err
+	}
+	var sum []byte
+	if params.Checksum != nil {
+		if _, sum, err = d.resolveChecksum(req.HTTPRequest, params.Checksum); err != nil {
+			return nil, err
+		}
+	}
+	var signature []byte
+	if params.SignatureURL != "" {
+		if signatureKey == nil {
+			return nil, ErrSignatureKeyNotConfigured
+		}
+		if signature, err = d.fetchSignature(req.HTTPRequest, params.SignatureURL); err != nil {
+			return nil, err
+		}
+	}
+	urls := append([]string{params.URL}, params.Mirrors...)
+	if params.FastestFirst && len(urls) > 1 {
+		urls = d.byLatency(ctx, params, urls)
+	}
+	var payload *DownloaderPayload
+	for i, url := range urls {
+		payload, err = d.executeFrom(ctx, params, url, sum, signature)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		if i < len(urls)-1 {
+			log.Warnf("Downloading %s failed: %v. trying %s", url, err, urls[i+1])
+		}
+	}
+	if err == nil && params.ExtractTo != "" {
+		payload.ExtractedFiles, err = Extract(ctx, payload.Filename, params.ExtractTo, params.StripComponents)
+	}
+	return payload, err
+}
+
+// executeFrom downloads the file of params from url and verifies it against sum and signature
+func (d *Downloader) executeFrom(ctx context.Context, params DownloaderParameters, url string, sum []byte, signature []byte) (*DownloaderPayload, error) {
+	req, err := d.newMirrorRequest(ctx, params, url)
+	if err != nil {
+		return nil, err
+	}
+	var h hash.Hash
+	if params.Checksum != nil {
+		if h, err = newChecksumHash(params.Checksum.Algorithm); err != nil {
+			return nil, err
+		}
+		req.SetChecksum(h, sum, true)
+	}
+	expected := ""
+	if params.Checksum != nil && strings.ToUpper(params.Checksum.Algorithm) == ChecksumSHA256 {
+		expected = hex.EncodeToString(sum)
+	}
+	payload, meta, err := d.fetch(req, expected)
+	if err == ErrBadChecksum && payload != nil {
+		// grab removed the file it transferred
+		if err := removeDownload(payload.Filename); err != nil {
+			log.Warnf("Failed removing %s: %v", payload.Filename, err)
+		}
+	}
+	if payload == nil {
+		return nil, err
+	}
+	payload.URL = url
+	if err == nil && payload.NotModified && h != nil {
+		// grab only verifies the files it transfers
+		err = verifyChecksum(payload.Filename, h, sum)
+	}
+	if err == nil && signature != nil {
+		err = verifySignature(payload.Filename, signature, signatureKey)
+	}
+	if err == nil {
+		d.remember(payload.Filename, meta)
+	}
+	return payload, err
+}
+
+// newMirrorRequest returns the request of the download of params from url, URL or one of its Mirrors.
+// The headers are only sent to the host of URL, see sameHost.
+func (d *Downloader) newMirrorRequest(ctx context.Context, params DownloaderParameters, url string) (*grab.Request, error) {
+	req, err := grab.NewRequest(params.FilePath, url)
+	if err != nil {
+		return nil, err
 	}
+	if primary, err := neturl.Parse(params.URL); err == nil && sameHost(primary, req.HTTPRequest.URL) {
+		setRequestHeader(req.HTTPRequest, d.Header, params.Header)
+	}
+	return req.WithContext(ctx), nil
+}
 
-	if resp.Start.IsZero() {
-		return fmt.Errorf("Response.Start is zero")
+// byLatency returns urls ordered by the time they take to answer a HEAD request. urls failing to
+// answer with a 2xx status come last, in their original order.
+func (d *Downloader) byLatency(ctx context.Context, params DownloaderParameters, urls []string) []string {
+	latencies := make([]time.Duration, len(urls))
+	var wg sync.WaitGroup
+	for i := range urls {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			latencies[i] = d.latency(ctx, params, urls[i])
+		}(i)
+	}
+	wg.Wait()
+	order := make([]int, len(urls))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return latencies[order[a]] < latencies[order[b]]
+	})
+	sorted := make([]string, len(urls))
+	for i, j := range order {
+		sorted[i] = urls[j]
+		log.Debugf("%s answered in %v", urls[j], latencies[j])
 	}
+	return sorted
+}
 
-	if resp.End.IsZero() {
-		return fmt.Errorf("Response.End is zero")
+// latency returns the time url takes to answer a HEAD request, or math.MaxInt64 if it fails to
+func (d *Downloader) latency(ctx context.Context, params DownloaderParameters, url string) time.Duration {
+	download, err := d.newMirrorRequest(ctx, params, url)
+	if err != nil {
+		return math.MaxInt64
+	}
+	req := download.HTTPRequest
+	req.Method = "HEAD"
+	start := time.Now()
+	resp, err := d.client.HTTPClient.Do(req)
+	if err != nil {
+		return math.MaxInt64
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return math.MaxInt64
 	}
+	return time.Since(start)
+}
 
-	if eta := resp.ETA(); eta != resp.End {
-		return fmt.Errorf("Response.ETA is not equal to Response.End: %v", eta)
+//ExecuteBatch executes Downloader's DownloadBatchWithContext with typed parameters and returns the
+// outcome of every file. The error of the failed downloads is returned along with the payload. With
+// ContinueOnError, failed downloads are only recorded in the payload unless every download failed.
+func (d *Downloader) ExecuteBatch(ctx context.Context, params DownloadBatchParameters) (*DownloadBatchPayload, error) {
+	urls := params.URLs
+	if params.Manifest != "" {
+		listed, err := readManifest(params.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(append([]string{}, urls...), listed...)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no urls to download")
 	}
+	files, err := d.downloadBatch(ctx, params.Directory, nil, params.Header, params.ContinueOnError, urls)
+	if err != nil {
+		return nil, err
+	}
+	payload := &DownloadBatchPayload{Files: files}
+	err = batchError(ctx, files)
+	if err != nil && params.ContinueOnError && ctx.Err() == nil {
+		for _, f := range files {
+			if f.Status == StatusSucceeded {
+				return payload, nil
+			}
+		}
+	}
+	return payload, err
+}
 
-	// the following fields should only be set if no error occurred
-	if resp.Err() == nil {
-		if resp.Filename == "" {
-			return fmt.Errorf("Response.Filename is empty")
+// readManifest returns the urls listed in filename, one per line. Empty lines and lines starting
+// with # are skipped.
+func readManifest(filename string) ([]string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
+		urls = append(urls, line)
 	}
-	return nil
+	return urls, nil
 }
 
-func getGrabHookFromHook(hook Hook) grab.Hook {
-	if hook != nil {
-		return func(resp *grab.Response) error {
-			return hook(resp.Filename)
+// fetch downloads req unless the file at its destination is unchanged. A file is unchanged if it has
+// the expected sha256 digest, when known, or if the server answers a request conditional on the ETag
+// and Last-Modified validators of its last download with 304 Not Modified. A file missing from the
+// destination is copied from the cache instead when the cached copy is unchanged.
+func (d *Downloader) fetch(req *grab.Request, expected string) (*DownloaderPayload, *downloadMetadata, error) {
+	filename, url := req.Filename, req.URL().String()
+	if fi, err := os.Stat(filename); err == nil && fi.IsDir() {
+		// the name of the file is only known once the server answered
+		return d.transfer(req)
+	}
+	var cache *downloadCache
+	if d.CacheDir != "" {
+		cache = &downloadCache{dir: d.CacheDir}
+	}
+	meta, source := localMetadata(filename, url), ""
+	if meta == nil && cache != nil {
+		if meta = cache.lookup(url); meta != nil {
+			source = cache.blobPath(meta.SHA256)
 		}
 	}
-	return nil
+	notModified := &DownloaderPayload{
+		Filename:    filename,
+		NotModified: true,
+	}
+	if expected != "" {
+		if meta == nil || meta.SHA256 != expected {
+			meta, source = newDownloadMetadata(url, nil), ""
+		}
+		if sum, err := fileSHA256(filename); err == nil && sum == expected {
+			return notModified, meta, nil
+		}
+		if cache != nil && cache.has(expected) {
+			if err := copyFile(cache.blobPath(expected), filename); err != nil {
+				return nil, nil, err
+			}
+			return notModified, meta, nil
+		}
+	}
+	if meta != nil && meta.hasValidators() && d.notModified(req.HTTPRequest, meta) {
+		if source != "" {
+			if err := copyFile(source, filename); err != nil {
+				return nil, nil, err
+			}
+		}
+		return notModified, meta, nil
+	}
+	if _, err := os.Stat(filename + metadataSuffix); err == nil && source == "" {
+		// the file was downloaded before and is outdated or changed locally. grab would keep it if its
+		// size did not change.
+		req.NoResume = true
+	}
+	return d.transfer(req)
+}
+
+// notModified reports whether the server answers a HEAD request conditional on the validators of m
+// with 304 Not Modified
+func (d *Downloader) notModified(download *http.Request, m *downloadMetadata) bool {
+	req := download.Clone(download.Context())
+	req.Method = "HEAD"
+	m.setConditions(req)
+	resp, err := d.client.HTTPClient.Do(req)
+	if err != nil {
+		log.Debugf("Conditional request for %s failed with %v", download.URL, err)
+		return false
+	}
+	resp.Body.Close()
+	log.Debugf("Conditional request for %s returned %s", download.URL, resp.Status)
+	return resp.StatusCode == http.StatusNotModified
+}
+
+func (d *Downloader) transfer(req *grab.Request) (*DownloaderPayload, *downloadMetadata, error) {
+	resp, err := d.download(req)
+	if resp == nil {
+		return nil, nil, err
+	}
+	var header http.Header
+	if resp.HTTPResponse != nil {
+		header = resp.HTTPResponse.Header
+	}
+	meta := newDownloadMetadata(req.URL().String(), header)
+	return &DownloaderPayload{
+		Filename:        resp.Filename,
+		BytesDownloaded: resp.BytesComplete(),
+	}, meta, err
+}
+
+// remember keeps the metadata of a verified download next to it and adds the download to the cache.
+// Failures are only logged since they merely cost a transfer on the next download.
+func (d *Downloader) remember(filename string, m *downloadMetadata) {
+	sum, err := fileSHA256(filename)
+	if err != nil {
+		log.Warnf("Failed reading %s: %v", filename, err)
+		return
+	}
+	m.SHA256 = sum
+	if err := writeMetadata(filename+metadataSuffix, m); err != nil {
+		log.Warnf("Failed writing metadata of %s: %v", filename, err)
+	}
+	if d.CacheDir != "" {
+		if err := (&downloadCache{dir: d.CacheDir}).store(filename, m); err != nil {
+			log.Warnf("Failed caching %s: %v", filename, err)
+		}
+	}
+}
+
+// resolveChecksum returns the hash and digest of checksum, fetching the digest from its sidecar file
+// if needed
+func (d *Downloader) resolveChecksum(download *http.Request, checksum *Checksum) (hash.Hash, []byte, error) {
+	h, err := newChecksumHash(checksum.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := checksum.Sum
+	if len(sum) == 0 {
+		b, err := fetchSidecar(d.client.HTTPClient, download, checksum.URL)
+		if err != nil {
+			return nil, nil, err
+		}
+		if sum, err = parseChecksum(string(b)); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", checksum.URL, err)
+		}
+	}
+	if len(sum) != h.Size() {
+		return nil, nil, fmt.Errorf("invalid %s checksum size %d. expected %d", checksum.Algorithm, len(sum), h.Size())
+	}
+	return h, sum, nil
 }
 
-//Execute executes Downloader's Download to implement Executable interface
-func (d *Downloader) Execute(params ...interface{}) (result []reflect.Value, err error) {
-	return execute(d.Download, params...)
+func (d *Downloader) fetchSignature(download *http.Request, url string) ([]byte, error) {
+	b, err := fetchSidecar(d.client.HTTPClient, download, url)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := parseSignature(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", url, err)
+	}
+	return sig, nil
 }
 
 //NewDownloader is the constructor for Downloader struct
@@ -154,5 +603,6 @@ func NewDownloader() *Downloader {
 		client:          grab.NewClient(),
 		UpdateTicker:    500,
 		BatchWorkerSize: 5,
+		CacheDir:        defaultDownloadCacheDir,
 	}
 }
@@ -1,13 +1,22 @@
 package nestor_test
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/jerminb/nestor"
+	"github.com/jerminb/nestor/lexer"
 	"github.com/jerminb/nestor/testserver"
 )
 
@@ -25,6 +34,15 @@ func fileCount(path string) (int, error) {
 	return i, nil
 }
 
+// newTestDir creates a temporary directory which the caller removes
+func newTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "nestor")
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	return dir
+}
+
 func TestDownload(t *testing.T) {
 	d := nestor.NewDownloader()
 	now := time.Now()
@@ -49,16 +67,44 @@ func TestExecute_Downloader(t *testing.T) {
 	filename := fmt.Sprintf("/tmp/nestor_tests/%d", nanos)
 	defer os.Remove(filename)
 	testserver.WithTestServer(t, func(url string) {
-		_, err := d.Execute(filename, url)
+		res, err := d.Execute(context.Background(), nestor.DownloaderParameters{
+			FilePath: filename,
+			URL:      url,
+		})
 		if err != nil {
 			t.Fatalf("expected no error. got %v", err)
 		}
+		if res.Filename != filename {
+			t.Fatalf("expected %s. got %s", filename, res.Filename)
+		}
+		if res.BytesDownloaded == 0 {
+			t.Fatalf("expected downloaded bytes. got 0")
+		}
 		if _, err := os.Stat(filename); os.IsNotExist(err) {
 			t.Fatalf("expected file in %s. got nil", filename)
 		}
 	})
 }
 
+func TestDownloadCancelled(t *testing.T) {
+	d := nestor.NewDownloader()
+	filename := fmt.Sprintf("/tmp/nestor_tests/%d", time.Now().UnixNano())
+	defer os.Remove(filename)
+	testserver.WithTestServer(t, func(url string) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+		defer cancel()
+		start := time.Now()
+		err := d.DownloadWithContext(ctx, filename, url)
+		if err == nil {
+			t.Fatalf("expected error. got nil")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second*2 {
+			t.Fatalf("expected download to be aborted after 200ms. got %v", elapsed)
+		}
+	},
+		testserver.TimeToFirstByte(time.Second))
+}
+
 func TestDownloadBatch(t *testing.T) {
 	tests := 32
 	d := nestor.NewDownloader()
@@ -89,6 +135,67 @@ func TestDownloadBatch(t *testing.T) {
 	})
 }
 
+// newAuthServer serves its request path, without the leading slash, to requests with the basic auth credentials admin:secret
+func newAuthServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/")))
+	}))
+}
+
+func TestDownloadHeader(t *testing.T) {
+	s := newAuthServer()
+	defer s.Close()
+	dir := fmt.Sprintf("/tmp/nestor_tests/header%d/", time.Now().UnixNano())
+	os.MkdirAll(dir, os.ModePerm)
+	defer os.RemoveAll(dir)
+	d := nestor.NewDownloader()
+	if err := d.Download(dir+"unauthorized", s.URL+"/unauthorized"); err == nil {
+		t.Fatalf("expected error. got nil")
+	}
+	d.Header = http.Header{}
+	d.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:secret")))
+	if err := d.Download(dir+"single", s.URL+"/single"); err != nil {
+		t.Fatalf("expected no error. got %v", err)
+	}
+	if err := d.DownloadBatch(dir, nil, s.URL+"/a", s.URL+"/b"); err != nil {
+		t.Fatalf("expected no error. got %v", err)
+	}
+	i, err := fileCount(dir)
+	if err != nil {
+		t.Fatalf("expected no error. got %v", err)
+	}
+	if i != 3 {
+		t.Fatalf("expected 3 files. got %d", i)
+	}
+}
+
+func TestExecute_DownloaderHeader(t *testing.T) {
+	s := newAuthServer()
+	defer s.Close()
+	filename := fmt.Sprintf("/tmp/nestor_tests/%d", time.Now().UnixNano())
+	defer os.Remove(filename)
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:secret")))
+	sum := sha256.Sum256([]byte("file"))
+	res, err := nestor.NewDownloader().Execute(context.Background(), nestor.DownloaderParameters{
+		FilePath: filename,
+		URL:      s.URL + "/file",
+		Header:   header,
+		// the sidecar is fetched with the same credentials
+		Checksum: &nestor.Checksum{Algorithm: nestor.ChecksumSHA256, URL: s.URL + "/" + hex.EncodeToString(sum[:])},
+	})
+	if err != nil {
+		t.Fatalf("expected no error. got %v", err)
+	}
+	if res.BytesDownloaded != int64(len("file")) {
+		t.Fatalf("expected %d bytes downloaded. got %d", len("file"), res.BytesDownloaded)
+	}
+}
+
 /*func TestAfterCopyHookPositive(t *testing.T) {
 	now := time.Now()
 	nanos := now.UnixNano()
@@ -126,3 +233,262 @@ func TestAfterCopyHookNegative(t *testing.T) {
 		}
 	})
 }*/
+
+func newContentServer(content string, delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte(content))
+	}))
+}
+
+func TestExecute_DownloaderMirrors(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	dead := newContentServer("", 0)
+	dead.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	corrupt := newContentServer("corrupt content", 0)
+	defer corrupt.Close()
+	mirror := newContentServer("mirrored content", 0)
+	defer mirror.Close()
+	sum := sha256.Sum256([]byte("mirrored content"))
+
+	d := nestor.NewDownloader()
+	filename := dir + "/file"
+	res := executeDownload(t, d, nestor.DownloaderParameters{
+		FilePath: filename,
+		URL:      dead.URL,
+		Mirrors:  []string{failing.URL, corrupt.URL, mirror.URL},
+		Checksum: &nestor.Checksum{Algorithm: nestor.ChecksumSHA256, Sum: sum[:]},
+	})
+	if res.URL != mirror.URL {
+		t.Fatalf("expected %s. got %s", mirror.URL, res.URL)
+	}
+	checkContent(t, filename, "mirrored content")
+
+	_, err := d.Execute(context.Background(), nestor.DownloaderParameters{
+		FilePath: dir + "/failed",
+		URL:      dead.URL,
+		Mirrors:  []string{failing.URL},
+	})
+	if err == nil {
+		t.Fatalf("expected error. got nil")
+	}
+}
+
+func TestExecute_DownloaderFastestMirror(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	slow := newContentServer("slow", 300*time.Millisecond)
+	defer slow.Close()
+	fast := newContentServer("fast", 0)
+	defer fast.Close()
+	params := nestor.DownloaderParameters{FilePath: dir + "/file", URL: slow.URL, Mirrors: []string{fast.URL}}
+	if res := executeDownload(t, nestor.NewDownloader(), params); res.URL != slow.URL {
+		t.Fatalf("expected %s. got %s", slow.URL, res.URL)
+	}
+	params.FilePath, params.FastestFirst = dir+"/fastest", true
+	if res := executeDownload(t, nestor.NewDownloader(), params); res.URL != fast.URL {
+		t.Fatalf("expected %s. got %s", fast.URL, res.URL)
+	}
+	checkContent(t, dir+"/fastest", "fast")
+}
+
+func TestExecute_DownloaderMirrorHeader(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	var mu sync.Mutex
+	var primaryAuth, mirrorAuth []string
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		primaryAuth = append(primaryAuth, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		mirrorAuth = append(mirrorAuth, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Write([]byte("mirrored content"))
+	}))
+	defer mirror.Close()
+	for _, fastest := range []bool{false, true} {
+		res := executeDownload(t, nestor.NewDownloader(), nestor.DownloaderParameters{
+			FilePath:     fmt.Sprintf("%s/%v", dir, fastest),
+			URL:          primary.URL,
+			Header:       http.Header{"Authorization": {"Bearer token"}},
+			Mirrors:      []string{mirror.URL},
+			FastestFirst: fastest,
+		})
+		if res.URL != mirror.URL {
+			t.Fatalf("expected %s. got %s", mirror.URL, res.URL)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, auth := range primaryAuth {
+		if auth != "Bearer token" {
+			t.Fatalf("expected Authorization sent to %s. got %q", primary.URL, auth)
+		}
+	}
+	if len(mirrorAuth) < 3 {
+		t.Fatalf("expected a probe and 2 downloads from %s. got %d requests", mirror.URL, len(mirrorAuth))
+	}
+	for _, auth := range mirrorAuth {
+		if auth != "" {
+			t.Fatalf("expected no Authorization sent to %s. got %q", mirror.URL, auth)
+		}
+	}
+}
+
+func TestDownloadMirrors(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	dead := newContentServer("", 0)
+	dead.Close()
+	mirror := newContentServer("mirrored content", 0)
+	defer mirror.Close()
+	res, err := executePoll(t, fmt.Sprintf(`download from "%s/f" or "%s/f" save to "%s/f"`, dead.URL, mirror.URL, dir))
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if p := res.Payload.(*nestor.DownloaderPayload); p.URL != mirror.URL+"/f" {
+		t.Fatalf("expected %s/f. got %s", mirror.URL, p.URL)
+	}
+	checkContent(t, dir+"/f", "mirrored content")
+}
+
+// newBatchServer serves its request path at /a and /b, fails /fail and answers /slow once the client is gone
+func newBatchServer() *httptest.Server {
+	mux := http.NewServeMux()
+	for _, p := range []string{"/a", "/b"} {
+		mux.HandleFunc(p, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.URL.Path))
+		})
+	}
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	})
+	return httptest.NewServer(mux)
+}
+
+func checkFileStatuses(t *testing.T, payload *nestor.DownloadBatchPayload, expected ...nestor.Status) {
+	if payload == nil || len(payload.Files) != len(expected) {
+		t.Fatalf("expected %d files. got %v", len(expected), payload)
+	}
+	for i, f := range payload.Files {
+		if f.Status != expected[i] {
+			t.Fatalf("expected %s for %s. got %s %v", expected[i], f.URL, f.Status, f.Error)
+		}
+	}
+}
+
+func TestExecuteBatchContinueOnError(t *testing.T) {
+	s := newBatchServer()
+	defer s.Close()
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	payload, err := nestor.NewDownloader().ExecuteBatch(context.Background(), nestor.DownloadBatchParameters{
+		Directory:       dir,
+		URLs:            []string{s.URL + "/a", s.URL + "/fail", s.URL + "/b"},
+		ContinueOnError: true,
+	})
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	checkFileStatuses(t, payload, nestor.StatusSucceeded, nestor.StatusFailed, nestor.StatusSucceeded)
+	if payload.Files[1].Error == nil {
+		t.Fatalf("expected the error of /fail. got nil")
+	}
+	checkContent(t, dir+"/a", "/a")
+	checkContent(t, dir+"/b", "/b")
+
+	// the batch fails once every download failed
+	payload, err = nestor.NewDownloader().ExecuteBatch(context.Background(), nestor.DownloadBatchParameters{
+		Directory:       dir,
+		URLs:            []string{s.URL + "/fail", s.URL + "/fail?again"},
+		ContinueOnError: true,
+	})
+	if err == nil || !strings.Contains(err.Error(), "/fail") {
+		t.Fatalf("expected error for /fail. got %v", err)
+	}
+	checkFileStatuses(t, payload, nestor.StatusFailed, nestor.StatusFailed)
+}
+
+func TestExecuteBatchAbort(t *testing.T) {
+	s := newBatchServer()
+	defer s.Close()
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	start := time.Now()
+	payload, err := nestor.NewDownloader().ExecuteBatch(context.Background(), nestor.DownloadBatchParameters{
+		Directory: dir,
+		URLs:      []string{s.URL + "/slow", s.URL + "/fail"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "/fail") {
+		t.Fatalf("expected error for /fail. got %v", err)
+	}
+	checkFileStatuses(t, payload, nestor.StatusCancelled, nestor.StatusFailed)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected /slow to be cancelled. took %v", elapsed)
+	}
+}
+
+func TestExecuteBatchManifest(t *testing.T) {
+	s := newBatchServer()
+	defer s.Close()
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	manifest := dir + "/manifest.txt"
+	if err := ioutil.WriteFile(manifest, []byte(fmt.Sprintf("# files\n\n  %s/b\n", s.URL)), 0644); err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	payload, err := nestor.NewDownloader().ExecuteBatch(context.Background(), nestor.DownloadBatchParameters{
+		Directory: dir,
+		URLs:      []string{s.URL + "/a"},
+		Manifest:  manifest,
+	})
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	checkFileStatuses(t, payload, nestor.StatusSucceeded, nestor.StatusSucceeded)
+	if payload.Files[1].URL != s.URL+"/b" || payload.Files[1].Filename != dir+"/b" {
+		t.Fatalf("expected %s/b saved to %s/b. got %v", s.URL, dir, payload.Files[1])
+	}
+}
+
+func TestDownloadAll(t *testing.T) {
+	s := newBatchServer()
+	defer s.Close()
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	q, err := lexer.NewParser(strings.NewReader(fmt.Sprintf(
+		`download all from ("%s/a", "%s/fail", "%s/b") save to "%s" on error continue; download from "%s/a" save to "%s/next"`,
+		s.URL, s.URL, s.URL, dir, s.URL, dir))).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	results, err := nestor.NewQueryExecutor().ExecuteQuery(q)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if len(results) != 2 || results[0].Status != nestor.StatusSucceeded {
+		t.Fatalf("expected the next statement to run. got %v", results)
+	}
+	payload, ok := results[0].Payload.(*nestor.DownloadBatchPayload)
+	if !ok {
+		t.Fatalf("expected *nestor.DownloadBatchPayload. got %T", results[0].Payload)
+	}
+	checkFileStatuses(t, payload, nestor.StatusSucceeded, nestor.StatusFailed, nestor.StatusSucceeded)
+	checkContent(t, dir+"/next", "/a")
+}
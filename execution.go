@@ -1,18 +1,29 @@
 package nestor
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
 	"reflect"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jerminb/nestor/lexer"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
 	defaultPollerHTTPMethod   string = "GET"
 	defaultPollerHTTPResponse string = "200 OK"
+	// defaultSQLSectionRegex applies every named section of a sql file
+	defaultSQLSectionRegex string = "(.*)"
+	// defaultSQLNamedQueryRegex matches both dotsql "-- name: foo" and pg_dump "-- Data for Name: foo;" annotations
+	defaultSQLNamedQueryRegex string = `^\s*--\s*(?:name|Data for Name):\s*([^;\s]+)`
 )
 
 //ExecuteAt is an enum to identify the execution step.
@@ -26,69 +37,422 @@ const (
 	ExecuteAtScheduled
 )
 
-// Executable is a generic interface for tasks to implement
+// Executable is a generic interface for tasks to implement. The typed parameters of a task are
+// bound when it is built from a statement and the returned payload is executor specific.
+// Implementations must stop and release their resources when ctx is cancelled.
 type Executable interface {
-	Execute(params ...interface{}) (result []reflect.Value, err error)
+	Execute(ctx context.Context) (payload interface{}, err error)
 }
 
-//ExecuteFromStatement executes a lexer.Statement using executable mapping which is implemented in getExecutableFromStatement
-func ExecuteFromStatement(stmt lexer.Statement) (result []reflect.Value, err error) {
-	exec, err := getExecutableFromStatement(stmt)
+//ExecutableFunc is an adapter to use an ordinary function as an Executable
+type ExecutableFunc func(ctx context.Context) (interface{}, error)
+
+//Execute calls f(ctx) to implement Executable interface
+func (f ExecutableFunc) Execute(ctx context.Context) (interface{}, error) {
+	return f(ctx)
+}
+
+//ExecuteFromStatement executes a lexer.Statement using executable mapping which is implemented in getExecutableFromStatement.
+// ${name} references are resolved from the process environment and SET statements before execution.
+// ${vault:path#key} references are resolved through the VaultService set by SetVaultService when the
+// statement's parameters are built, so secrets never show up in the statement itself.
+// The returned Result is never nil and the returned error is the Result's Error.
+func ExecuteFromStatement(stmt lexer.Statement) (*Result, error) {
+	return ExecuteFromStatementWithContext(context.Background(), stmt)
+}
+
+//ExecuteFromStatementWithContext is ExecuteFromStatement that cancels the statement when ctx is done.
+// A statement's TIMEOUT clause further limits its own execution. A statement with a SCHEDULE clause
+// runs on its schedule until ctx is done; its TIMEOUT then limits every run.
+func ExecuteFromStatementWithContext(ctx context.Context, stmt lexer.Statement) (*Result, error) {
+	start := time.Now()
+	resolved, err := ResolveVariables(stmt, NewVariablesFromEnvironment(), nil)
 	if err != nil {
-		return nil, err
+		return newResult(stmt, start, nil, err), err
+	}
+	r := executeStatement(ctx, resolved)
+	return r, r.Error
+}
+
+//WithTerminationSignals returns a copy of ctx that is cancelled on SIGINT or SIGTERM
+func WithTerminationSignals(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Infof("Received %v. Cancelling execution", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
+
+func getStatementContext(ctx context.Context, stmt lexer.Statement) (context.Context, context.CancelFunc, error) {
+	b, ok := stmt.(baser)
+	if !ok || b.Base().Timeout == "" {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	timeout, err := time.ParseDuration(b.Base().Timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, nil
+}
+
+func executeStatement(ctx context.Context, stmt lexer.Statement) *Result {
+	start := time.Now()
+	// variables are applied while resolving, so there is nothing left to execute
+	if _, ok := stmt.(*lexer.SetStatement); ok {
+		return newResult(stmt, start, nil, nil)
+	}
+	if getExecuteAt(stmt) == ExecuteAtScheduled {
+		payload, err := executeScheduled(ctx, stmt)
+		return newResult(stmt, start, payload, err)
 	}
-	params, err := getParameterForExecutable(stmt)
+	return runStatement(ctx, stmt)
+}
+
+// runStatement executes stmt once. The statement's TIMEOUT applies to every run.
+func runStatement(ctx context.Context, stmt lexer.Statement) *Result {
+	start := time.Now()
+	stmtCtx, cancel, err := getStatementContext(ctx, stmt)
+	if err != nil {
+		return newResult(stmt, start, nil, err)
+	}
+	defer cancel()
+	secrets := newSecretResolver(defaultVaultService)
+	payload, err := executeWithSecrets(stmtCtx, stmt, secrets)
+	// the status is decided on the error as returned, redaction loses the errors it wraps
+	r := newResult(stmt, start, payload, err)
+	r.Error = secrets.Redact(err)
+	return r
+}
+
+func executeWithSecrets(ctx context.Context, stmt lexer.Statement, secrets *secretResolver) (interface{}, error) {
+	// statements of a query resolve their own secrets when they are executed
+	if !isQuery(stmt) {
+		var err error
+		stmt, err = secrets.resolveSecrets(stmt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	exec, err := getExecutableFromStatement(stmt)
 	if err != nil {
 		return nil, err
 	}
-	return exec.Execute(params...)
+	payload, err := exec.Execute(ctx)
+	payload = untypedNil(payload)
+	secrets.RedactPayload(payload)
+	return payload, err
+}
+
+// untypedNil returns nil for a nil pointer payload, so that executables returning a typed payload
+// leave the payload of their result nil when they have none
+func untypedNil(payload interface{}) interface{} {
+	if v := reflect.ValueOf(payload); v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil
+	}
+	return payload
 }
 
 func getExecutableFromStatement(stmt lexer.Statement) (Executable, error) {
-	expected := []string{"PollStatement", "DownloadStatement", "SQLExecuteStatement"}
+	expected := []string{"PollStatement", "DownloadStatement", "SQLExecuteStatement", "RefreshStatement", "Query"}
 	switch v := stmt.(type) {
 	case *(lexer.PollStatement):
-		return NewPoller(), nil
+		p, err := getPollerExecutable(v)
+		if err != nil {
+			return nil, err
+		}
+		params, err := getPollerExecutableParameters(v)
+		if err != nil {
+			return nil, err
+		}
+		return ExecutableFunc(func(ctx context.Context) (interface{}, error) {
+			return p.Execute(ctx, params)
+		}), nil
 	case *(lexer.DownloadStatement):
-		return NewDownloader(), nil
+		d := NewDownloader()
+		if v.All {
+			params := getDownloadBatchExecutableParameters(v)
+			return ExecutableFunc(func(ctx context.Context) (interface{}, error) {
+				return d.ExecuteBatch(ctx, params)
+			}), nil
+		}
+		params, err := getDownloaderExecutableParameters(v)
+		if err != nil {
+			return nil, err
+		}
+		return ExecutableFunc(func(ctx context.Context) (interface{}, error) {
+			return d.Execute(ctx, params)
+		}), nil
+	case *(lexer.SQLExecuteStatement):
+		params, err := getDatabaserExecutableParameters(v)
+		if err != nil {
+			return nil, err
+		}
+		d := NewDatabaser()
+		return ExecutableFunc(func(ctx context.Context) (interface{}, error) {
+			return d.Execute(ctx, params)
+		}), nil
+	case *(lexer.RefreshStatement):
+		if defaultVaultService == nil {
+			return nil, ErrVaultNotConfigured
+		}
+		r, err := NewRefresher(defaultVaultService, nil)
+		if err != nil {
+			return nil, err
+		}
+		params, err := getRefresherExecutableParameters(v)
+		if err != nil {
+			return nil, err
+		}
+		return ExecutableFunc(func(ctx context.Context) (interface{}, error) {
+			return r.Execute(ctx, params)
+		}), nil
+	case *(lexer.Query):
+		qe := NewQueryExecutor()
+		return ExecutableFunc(func(ctx context.Context) (interface{}, error) {
+			return qe.Execute(ctx, v)
+		}), nil
 	default:
+		if factory := lookupExecutableFactory(stmt); factory != nil {
+			return factory(stmt)
+		}
 		return nil, fmt.Errorf("found %v. expected %s", v, strings.Join(expected, ", "))
 	}
 }
 
-func getParameterForExecutable(stmt lexer.Statement) ([]interface{}, error) {
-	expected := []string{"PollStatement", "DownloadStatement", "SQLExecuteStatement"}
-	switch v := stmt.(type) {
-	case *(lexer.PollStatement):
-		return getPollerExecutableParameters(stmt.(*(lexer.PollStatement)))
-	case *(lexer.DownloadStatement):
-		return getDownloaderExecutableParameters(stmt.(*(lexer.DownloadStatement)))
-	default:
-		return nil, fmt.Errorf("found %v. expected %s", v, strings.Join(expected, ", "))
+func getPollerExecutable(pollstmt *lexer.PollStatement) (*Poller, error) {
+	poller := NewPoller()
+	if pollstmt.InitialWaitTime != "" {
+		initialWaitTime, err := time.ParseDuration(pollstmt.InitialWaitTime)
+		if err != nil {
+			return nil, err
+		}
+		poller.InitialWaitTime = initialWaitTime
+	}
+	if pollstmt.Deadline != "" {
+		deadline, err := time.ParseDuration(pollstmt.Deadline)
+		if err != nil {
+			return nil, err
+		}
+		poller.Deadline = deadline
 	}
+	if pollstmt.UntilDown {
+		poller.UntilDown = 1
+		if pollstmt.DownCount != "" {
+			downCount, err := strconv.Atoi(pollstmt.DownCount)
+			if err != nil {
+				return nil, err
+			}
+			if downCount <= 0 {
+				return nil, fmt.Errorf("down count must be greater than zero")
+			}
+			poller.UntilDown = downCount
+		}
+	}
+	return poller, nil
 }
 
-func getPollerExecutableParameters(pollstmt *lexer.PollStatement) ([]interface{}, error) {
+func getPollerExecutableParameters(pollstmt *lexer.PollStatement) (PollerParameters, error) {
 	maxRetryCount, err := strconv.Atoi(pollstmt.MaxRetryCount)
 	if err != nil {
-		return nil, err
+		return PollerParameters{}, err
 	}
 	interval, err := time.ParseDuration(pollstmt.Interval)
 	if err != nil {
-		return nil, err
+		return PollerParameters{}, err
 	}
-	params := make([]interface{}, 0)
-	params = append(params, pollstmt.URL)
-	params = append(params, defaultPollerHTTPMethod)
-	params = append(params, maxRetryCount)
-	params = append(params, defaultPollerHTTPResponse)
-	params = append(params, interval)
-	return params, nil
+	expectations, err := getPollerExpectations(pollstmt)
+	if err != nil {
+		return PollerParameters{}, err
+	}
+	backoff, err := getPollerBackoff(pollstmt, interval)
+	if err != nil {
+		return PollerParameters{}, err
+	}
+	quorum, err := getPollerQuorum(pollstmt)
+	if err != nil {
+		return PollerParameters{}, err
+	}
+	method := defaultPollerHTTPMethod
+	if pollstmt.Request.Method != "" {
+		method = strings.ToUpper(pollstmt.Request.Method)
+	}
+	return PollerParameters{
+		URL:           pollstmt.URL,
+		HTTPMethod:    method,
+		Header:        getRequestHeader(pollstmt.Request),
+		Body:          pollstmt.Request.Body,
+		MaxErrorCount: maxRetryCount,
+		SuccessStatus: defaultPollerHTTPResponse,
+		Expectations:  expectations,
+		Interval:      interval,
+		Backoff:       backoff,
+		URLs:          pollstmt.URLs,
+		Quorum:        quorum,
+	}, nil
+}
+
+// getPollerQuorum returns the count of endpoints that must succeed; 0 for ALL and 1 for ANY
+func getPollerQuorum(pollstmt *lexer.PollStatement) (int, error) {
+	switch strings.ToUpper(pollstmt.Quorum) {
+	case "", lexer.ALL.String():
+		return 0, nil
+	case lexer.ANY.String():
+		return 1, nil
+	}
+	quorum, err := strconv.Atoi(pollstmt.Quorum)
+	if err != nil {
+		return 0, err
+	}
+	if quorum <= 0 || quorum > len(pollstmt.Endpoints()) {
+		return 0, fmt.Errorf("quorum must be between 1 and %d", len(pollstmt.Endpoints()))
+	}
+	return quorum, nil
+}
+
+// getPollerBackoff returns the backoff of a BACKOFF clause based on the polling interval,
+// or nil for a fixed interval
+func getPollerBackoff(pollstmt *lexer.PollStatement, interval time.Duration) (Backoff, error) {
+	if pollstmt.Backoff == nil {
+		return nil, nil
+	}
+	var max time.Duration
+	if pollstmt.Backoff.Max != "" {
+		var err error
+		if max, err = time.ParseDuration(pollstmt.Backoff.Max); err != nil {
+			return nil, err
+		}
+	}
+	return NewBackoff(pollstmt.Backoff.Policy.String(), interval, max)
 }
 
-func getDownloaderExecutableParameters(dlstmt *lexer.DownloadStatement) ([]interface{}, error) {
-	params := make([]interface{}, 0)
-	params = append(params, dlstmt.FilePath)
-	params = append(params, dlstmt.URL)
+// getRequestHeader returns the headers of the HEADER and AUTH clauses of a statement
+func getRequestHeader(opts lexer.RequestOptions) http.Header {
+	header := make(http.Header)
+	for _, h := range opts.Headers {
+		header.Add(h.Name, h.Value)
+	}
+	if opts.Auth != nil {
+		switch opts.Auth.Scheme {
+		case lexer.BASIC:
+			credentials := base64.StdEncoding.EncodeToString([]byte(opts.Auth.Username + ":" + opts.Auth.Password))
+			header.Set("Authorization", "Basic "+credentials)
+		case lexer.BEARER:
+			header.Set("Authorization", "Bearer "+opts.Auth.Token)
+		}
+	}
+	return header
+}
+
+// getPollerExpectations maps the EXPECT conditions of a poll statement. Without an EXPECT STATUS
+// condition the response status must still be the default success status.
+func getPollerExpectations(pollstmt *lexer.PollStatement) ([]*Expectation, error) {
+	expectations := make([]*Expectation, 0, len(pollstmt.Expectations)+1)
+	hasStatus := false
+	for _, e := range pollstmt.Expectations {
+		exp, err := NewExpectation(e.Subject.String(), e.Path, e.Operator.String(), e.Value)
+		if err != nil {
+			return nil, err
+		}
+		hasStatus = hasStatus || exp.Subject == ExpectStatus
+		expectations = append(expectations, exp)
+	}
+	if !hasStatus {
+		expectations = append([]*Expectation{NewStatusExpectation(defaultPollerHTTPResponse)}, expectations...)
+	}
+	return expectations, nil
+}
+
+func getDownloaderExecutableParameters(dlstmt *lexer.DownloadStatement) (DownloaderParameters, error) {
+	params := DownloaderParameters{
+		FilePath:     dlstmt.FilePath,
+		URL:          dlstmt.URL,
+		Header:       getRequestHeader(dlstmt.Request),
+		Mirrors:      dlstmt.Mirrors,
+		FastestFirst: dlstmt.FastestFirst,
+	}
+	if dlstmt.Extract != nil {
+		params.ExtractTo = dlstmt.Extract.Directory
+		if dlstmt.Extract.StripComponents != "" {
+			strip, err := strconv.Atoi(dlstmt.Extract.StripComponents)
+			if err != nil {
+				return DownloaderParameters{}, err
+			}
+			if strip < 0 {
+				return DownloaderParameters{}, fmt.Errorf("strip components cannot be negative")
+			}
+			params.StripComponents = strip
+		}
+	}
+	for _, v := range dlstmt.Verifications {
+		switch v.Algorithm {
+		case lexer.SIGNATURE:
+			if params.SignatureURL != "" {
+				return DownloaderParameters{}, fmt.Errorf("a single VERIFY SIGNATURE clause is allowed")
+			}
+			params.SignatureURL = v.From
+		default:
+			if params.Checksum != nil {
+				return DownloaderParameters{}, fmt.Errorf("a single VERIFY checksum clause is allowed")
+			}
+			checksum := &Checksum{
+				Algorithm: v.Algorithm.String(),
+				URL:       v.From,
+			}
+			if v.From == "" {
+				sum, err := parseChecksum(v.Checksum)
+				if err != nil {
+					return DownloaderParameters{}, err
+				}
+				checksum.Sum = sum
+			}
+			params.Checksum = checksum
+		}
+	}
 	return params, nil
 }
+
+func getDownloadBatchExecutableParameters(dlstmt *lexer.DownloadStatement) DownloadBatchParameters {
+	return DownloadBatchParameters{
+		Directory:       dlstmt.FilePath,
+		URLs:            dlstmt.URLs,
+		Manifest:        dlstmt.Manifest,
+		Header:          getRequestHeader(dlstmt.Request),
+		ContinueOnError: dlstmt.ContinueOnError,
+	}
+}
+
+func getDatabaserExecutableParameters(sqlstmt *lexer.SQLExecuteStatement) (DatabaserParameters, error) {
+	db, err := GetSQLDB(sqlstmt.DBConnectionString)
+	if err != nil {
+		return DatabaserParameters{}, err
+	}
+	return DatabaserParameters{
+		FilePath:        sqlstmt.FilePath,
+		SectionRegex:    defaultSQLSectionRegex,
+		NamedQueryRegex: defaultSQLNamedQueryRegex,
+		DB:              db,
+	}, nil
+}
+
+func getRefresherExecutableParameters(refreshstmt *lexer.RefreshStatement) (RefresherParameters, error) {
+	interval, err := time.ParseDuration(refreshstmt.Interval)
+	if err != nil {
+		return RefresherParameters{}, err
+	}
+	return RefresherParameters{
+		Artifact:    refreshstmt.Artifact,
+		Path:        refreshstmt.Path,
+		Destination: refreshstmt.Destination,
+		Interval:    interval,
+	}, nil
+}
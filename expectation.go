@@ -0,0 +1,199 @@
+package nestor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	//ExpectStatus is the subject of an expectation on the response status
+	ExpectStatus string = "STATUS"
+	//ExpectBody is the subject of an expectation on the response body
+	ExpectBody string = "BODY"
+	//ExpectJSON is the subject of an expectation on a value of a JSON response body
+	ExpectJSON string = "JSON"
+
+	// maxExpectedBodySize bounds the part of a response body that expectations are evaluated against
+	maxExpectedBodySize int64 = 1 << 20
+)
+
+//Expectation is a condition a polled response must meet to be successful.
+// Operator is one of =, !=, =~ and !~. A STATUS value matches either the status code, e.g. 204,
+// or the full status, e.g. "204 No Content". A JSON value is compared to the value found at Path,
+// a dot separated path with optional [n] indexes and an optional leading $, e.g. $.checks[0].status.
+type Expectation struct {
+	Subject  string
+	Path     string
+	Operator string
+	Value    string
+	regex    *regexp.Regexp
+	path     []interface{}
+}
+
+//NewExpectation is constructor for Expectation class
+func NewExpectation(subject string, path string, operator string, value string) (*Expectation, error) {
+	e := &Expectation{
+		Subject:  strings.ToUpper(subject),
+		Path:     path,
+		Operator: operator,
+		Value:    value,
+	}
+	switch e.Subject {
+	case ExpectStatus, ExpectBody:
+	case ExpectJSON:
+		p, err := parseJSONPath(path)
+		if err != nil {
+			return nil, err
+		}
+		e.path = p
+	default:
+		return nil, fmt.Errorf("found %s. expected %s, %s, %s", subject, ExpectStatus, ExpectBody, ExpectJSON)
+	}
+	switch operator {
+	case "=", "!=":
+	case "=~", "!~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		e.regex = re
+	default:
+		return nil, fmt.Errorf("found %s. expected =, !=, =~, !~", operator)
+	}
+	return e, nil
+}
+
+//NewStatusExpectation returns an expectation on a response status such as "200 OK" or "204"
+func NewStatusExpectation(status string) *Expectation {
+	return &Expectation{
+		Subject:  ExpectStatus,
+		Operator: "=",
+		Value:    status,
+	}
+}
+
+func (e *Expectation) String() string {
+	if e.Subject == ExpectJSON {
+		return fmt.Sprintf("%s %s %s %s", e.Subject, e.Path, e.Operator, e.Value)
+	}
+	return fmt.Sprintf("%s %s %s", e.Subject, e.Operator, e.Value)
+}
+
+//needsBody reports whether the expectation is evaluated against the response body
+func (e *Expectation) needsBody() bool {
+	return e.Subject != ExpectStatus
+}
+
+//Match evaluates the expectation against a response and its body.
+// body is only read for BODY and JSON expectations.
+func (e *Expectation) Match(resp *http.Response, body []byte) (bool, error) {
+	switch e.Subject {
+	case ExpectStatus:
+		if e.regex == nil {
+			equal := e.Value == strconv.Itoa(resp.StatusCode) || e.Value == resp.Status
+			return equal == (e.Operator != "!="), nil
+		}
+		return e.compare(resp.Status), nil
+	case ExpectBody:
+		return e.compare(string(body)), nil
+	case ExpectJSON:
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return false, fmt.Errorf("response body is not JSON: %v", err)
+		}
+		v, ok := lookupJSONPath(doc, e.path)
+		if !ok {
+			return false, nil
+		}
+		return e.compare(jsonValueString(v)), nil
+	}
+	return false, fmt.Errorf("found %s. expected %s, %s, %s", e.Subject, ExpectStatus, ExpectBody, ExpectJSON)
+}
+
+func (e *Expectation) compare(s string) bool {
+	switch e.Operator {
+	case "=~":
+		return e.regex.MatchString(s)
+	case "!~":
+		return !e.regex.MatchString(s)
+	case "!=":
+		return s != e.Value
+	}
+	return s == e.Value
+}
+
+// parseJSONPath splits a path like $.checks[0].status into map keys (string) and slice indexes (int)
+func parseJSONPath(path string) ([]interface{}, error) {
+	p := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if p == "" {
+		return nil, nil
+	}
+	var elems []interface{}
+	for _, part := range strings.Split(p, ".") {
+		key := part
+		var indexes []interface{}
+		if i := strings.Index(part, "["); i >= 0 {
+			key = part[:i]
+			rest := part[i:]
+			for rest != "" {
+				end := strings.Index(rest, "]")
+				if rest[0] != '[' || end < 0 {
+					return nil, fmt.Errorf("invalid json path %s", path)
+				}
+				n, err := strconv.Atoi(rest[1:end])
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid index in json path %s", path)
+				}
+				indexes = append(indexes, n)
+				rest = rest[end+1:]
+			}
+		}
+		if key == "" && len(indexes) == 0 {
+			return nil, fmt.Errorf("invalid json path %s", path)
+		}
+		if key != "" {
+			elems = append(elems, key)
+		}
+		elems = append(elems, indexes...)
+	}
+	return elems, nil
+}
+
+func lookupJSONPath(doc interface{}, path []interface{}) (interface{}, bool) {
+	v := doc
+	for _, elem := range path {
+		switch p := elem.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[p]; !ok {
+				return nil, false
+			}
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || p >= len(a) {
+				return nil, false
+			}
+			v = a[p]
+		}
+	}
+	return v, true
+}
+
+// jsonValueString returns strings as they are and any other value as JSON, e.g. true, 42 or null
+func jsonValueString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,76 @@
+package nestor_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jerminb/nestor"
+)
+
+func TestExpectationMatch(t *testing.T) {
+	body := []byte(`{"status":"UP","checks":[{"name":"db","status":"DOWN"}],"uptime":42,"ready":true}`)
+	resp := &http.Response{StatusCode: 204, Status: "204 No Content"}
+	var tests = []struct {
+		subject  string
+		path     string
+		operator string
+		value    string
+		match    bool
+	}{
+		{"STATUS", "", "=", "204", true},
+		{"STATUS", "", "=", "204 No Content", true},
+		{"STATUS", "", "=", "200 OK", false},
+		{"STATUS", "", "!=", "200", true},
+		{"STATUS", "", "=~", "^2", true},
+		{"STATUS", "", "!~", "^2", false},
+		{"BODY", "", "=~", `"status":"UP"`, true},
+		{"BODY", "", "!~", `"status":"UP"`, false},
+		{"JSON", "$.status", "=", "UP", true},
+		{"JSON", "status", "!=", "UP", false},
+		{"JSON", "$.checks[0].status", "=", "DOWN", true},
+		{"JSON", "$.checks[1].status", "=", "DOWN", false},
+		{"JSON", "$.uptime", "=", "42", true},
+		{"JSON", "$.ready", "=~", "^true$", true},
+		{"JSON", "$.missing", "!=", "UP", false},
+	}
+	for _, c := range tests {
+		e, err := nestor.NewExpectation(c.subject, c.path, c.operator, c.value)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		ok, err := e.Match(resp, body)
+		if err != nil {
+			t.Fatalf("expected nil for %v. got %v", e, err)
+		}
+		if ok != c.match {
+			t.Fatalf("expected %v for %v. got %v", c.match, e, ok)
+		}
+	}
+}
+
+func TestExpectationNegative(t *testing.T) {
+	var tests = []struct {
+		subject  string
+		path     string
+		operator string
+		value    string
+	}{
+		{"HEADER", "", "=", "foo"},
+		{"BODY", "", "<", "foo"},
+		{"BODY", "", "=~", "(foo"},
+		{"JSON", "$.checks[x]", "=", "foo"},
+		{"JSON", "$..status", "=", "foo"},
+	}
+	for _, c := range tests {
+		if _, err := nestor.NewExpectation(c.subject, c.path, c.operator, c.value); err == nil {
+			t.Fatalf("expected error for %v. got nil", c)
+		}
+	}
+	e, err := nestor.NewExpectation("JSON", "status", "=", "UP")
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if _, err := e.Match(&http.Response{StatusCode: 200}, []byte("<html>")); err == nil {
+		t.Fatalf("expected error for a body that is not JSON. got nil")
+	}
+}
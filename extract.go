@@ -0,0 +1,350 @@
+package nestor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+//Extract unpacks the tar, gzip or zstd compressed tar, or zip archive at filename into dir and returns
+// the paths of the extracted files relative to dir. The format is detected from the content of the file.
+// The first strip elements of every entry path are removed and entries without remaining elements are
+// skipped. Entries and links that would resolve outside of dir are rejected. Permission bits are kept.
+func Extract(ctx context.Context, filename string, dir string, strip int) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	magic := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	magic = magic[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	x := &extractor{ctx: ctx, dir: filepath.Clean(dir), strip: strip}
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(f, fi.Size())
+		if err != nil {
+			return nil, err
+		}
+		err = x.extractZip(zr)
+		return x.files, err
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		err = x.extractTar(gz)
+		return x.files, err
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		err = x.extractTar(zr)
+		return x.files, err
+	}
+	err = x.extractTar(f)
+	return x.files, err
+}
+
+// extractor writes the entries of an archive below dir. The modes of dirs are only set once every
+// entry is written.
+type extractor struct {
+	ctx   context.Context
+	dir   string
+	strip int
+	files []string
+	dirs  []string
+	modes []os.FileMode
+}
+
+func (x *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		if err := x.ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return x.setDirModes()
+		}
+		if err != nil {
+			return err
+		}
+		target, rel, err := x.target(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+		mode := hdr.FileInfo().Mode().Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.mkdir(target, mode)
+		case tar.TypeReg, tar.TypeRegA:
+			err = x.writeFile(target, rel, tr, mode)
+		case tar.TypeSymlink:
+			err = x.symlink(target, rel, hdr.Linkname)
+		case tar.TypeLink:
+			err = x.link(target, rel, hdr.Linkname)
+		default:
+			// devices, fifos and pax records are not extracted
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (x *extractor) extractZip(zr *zip.Reader) error {
+	for _, f := range zr.File {
+		if err := x.ctx.Err(); err != nil {
+			return err
+		}
+		target, rel, err := x.target(f.Name)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.mkdir(target, mode.Perm())
+		case mode&os.ModeSymlink != 0:
+			err = x.extractZipSymlink(f, target, rel)
+		default:
+			err = x.extractZipFile(f, target, rel)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return x.setDirModes()
+}
+
+func (x *extractor) extractZipFile(f *zip.File, target string, rel string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return x.writeFile(target, rel, r, f.Mode().Perm())
+}
+
+func (x *extractor) extractZipSymlink(f *zip.File, target string, rel string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	var linkname bytes.Buffer
+	if _, err := io.Copy(&linkname, io.LimitReader(r, 4096)); err != nil {
+		return err
+	}
+	return x.symlink(target, rel, linkname.String())
+}
+
+// target returns the path of an archive entry below dir and its path relative to dir.
+// Both are empty for entries removed by strip.
+func (x *extractor) target(name string) (string, string, error) {
+	if path.IsAbs(name) || strings.HasPrefix(name, `\`) {
+		return "", "", fmt.Errorf("illegal absolute path %s in archive", name)
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", "", fmt.Errorf("illegal path %s in archive", name)
+		}
+	}
+	clean := path.Clean(name)
+	if clean == "." {
+		return "", "", nil
+	}
+	elems := strings.Split(clean, "/")
+	if len(elems) <= x.strip {
+		return "", "", nil
+	}
+	rel := path.Join(elems[x.strip:]...)
+	return filepath.Join(x.dir, filepath.FromSlash(rel)), rel, nil
+}
+
+// within reports whether p, once the links of its existing elements are followed, is dir or below it
+func (x *extractor) within(p string) (bool, error) {
+	resolved, err := resolveLinks(p)
+	if err != nil {
+		return false, err
+	}
+	root, err := filepath.EvalSymlinks(x.dir)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
+
+// resolveLinks returns p with the links of its longest existing leading path followed. p is not
+// cleaned beforehand so that a .. following a link applies to the target of the link. The elements
+// which do not exist yet are joined as they are.
+func resolveLinks(p string) (string, error) {
+	sep := string(filepath.Separator)
+	elems := strings.Split(p, sep)
+	for i := len(elems); i > 0; i-- {
+		prefix := strings.Join(elems[:i], sep)
+		if prefix == "" {
+			prefix = sep
+		}
+		resolved, err := filepath.EvalSymlinks(prefix)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, elems[i:]...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return filepath.Clean(p), nil
+}
+
+func (x *extractor) mkdir(target string, mode os.FileMode) error {
+	if ok, err := x.within(target); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("illegal path %s in archive", target)
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	x.dirs = append(x.dirs, target)
+	x.modes = append(x.modes, mode)
+	return nil
+}
+
+// setDirModes sets the modes of directories last so read-only directories can still be filled
+func (x *extractor) setDirModes() error {
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(x.dirs[i], x.modes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) writeFile(target string, rel string, r io.Reader, mode os.FileMode) error {
+	if err := x.prepare(target); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	// the mode of a new file is masked by the umask
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	x.files = append(x.files, rel)
+	return nil
+}
+
+func (x *extractor) symlink(target string, rel string, linkname string) error {
+	if filepath.IsAbs(linkname) {
+		return fmt.Errorf("illegal link %s -> %s in archive", rel, linkname)
+	}
+	// links extracted earlier are followed so that chains of links cannot leave dir
+	if ok, err := x.within(filepath.Dir(target) + string(filepath.Separator) + filepath.FromSlash(linkname)); err != nil || !ok {
+		return fmt.Errorf("illegal link %s -> %s in archive", rel, linkname)
+	}
+	if err := x.prepare(target); err != nil {
+		return err
+	}
+	if err := os.Symlink(linkname, target); err != nil {
+		return err
+	}
+	x.files = append(x.files, rel)
+	return nil
+}
+
+// link creates a hard link to linkname, the path of an earlier entry of the archive
+func (x *extractor) link(target string, rel string, linkname string) error {
+	source, _, err := x.target(linkname)
+	if err != nil {
+		return err
+	}
+	if source == "" {
+		return fmt.Errorf("link %s -> %s in archive is removed by strip", rel, linkname)
+	}
+	if err := x.prepare(target); err != nil {
+		return err
+	}
+	// the parents of source are followed by os.Link
+	parent, err := filepath.EvalSymlinks(filepath.Dir(source))
+	if err != nil {
+		return err
+	}
+	source = filepath.Join(parent, filepath.Base(source))
+	if ok, err := x.within(source); err != nil || !ok {
+		return fmt.Errorf("illegal link %s -> %s in archive", rel, linkname)
+	}
+	if err := os.Link(source, target); err != nil {
+		return err
+	}
+	x.files = append(x.files, rel)
+	return nil
+}
+
+// prepare creates the parent directories of target and removes a file or link already at target.
+// Parents are resolved so that links extracted earlier cannot redirect target outside of dir.
+func (x *extractor) prepare(target string) error {
+	parent := filepath.Dir(target)
+	if ok, err := x.within(parent); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("illegal path %s in archive", target)
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+		return os.Remove(target)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package nestor_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jerminb/nestor"
+	"github.com/klauspost/compress/zstd"
+)
+
+type archiveEntry struct {
+	name     string
+	body     string
+	mode     int64
+	typeflag byte
+	linkname string
+}
+
+var testArchiveEntries = []archiveEntry{
+	{name: "app-1.0/", mode: 0755, typeflag: tar.TypeDir},
+	{name: "app-1.0/bin/run", body: "#!/bin/sh\n", mode: 0755, typeflag: tar.TypeReg},
+	{name: "app-1.0/README", body: "readme", mode: 0600, typeflag: tar.TypeReg},
+	{name: "app-1.0/bin/latest", mode: 0777, typeflag: tar.TypeSymlink, linkname: "run"},
+}
+
+func newTar(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: e.mode, Typeflag: e.typeflag, Linkname: e.linkname, Size: int64(len(e.body))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	return buf.Bytes()
+}
+
+func newTarGz(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(newTar(t, entries))
+	if err := gz.Close(); err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	return buf.Bytes()
+}
+
+func newTarZst(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	zw.Write(newTar(t, entries))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	return buf.Bytes()
+}
+
+func newZip(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		mode := os.FileMode(e.mode)
+		body := e.body
+		switch e.typeflag {
+		case tar.TypeDir:
+			mode |= os.ModeDir
+		case tar.TypeSymlink:
+			mode |= os.ModeSymlink
+			body = e.linkname
+		}
+		hdr.SetMode(mode)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		w.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	return buf.Bytes()
+}
+
+// writeArchive writes archive to a new temporary directory and returns the directory and the archive path
+func writeArchive(t *testing.T, archive []byte) (string, string) {
+	dir := newTestDir(t)
+	filename := filepath.Join(dir, "archive")
+	if err := ioutil.WriteFile(filename, archive, 0644); err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	return dir, filename
+}
+
+func checkExtracted(t *testing.T, dir string, files []string) {
+	expected := []string{"bin/run", "README", "bin/latest"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected %v. got %v", expected, files)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "bin", "run"))
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if fi.Mode().Perm() != 0755 {
+		t.Fatalf("expected mode 0755. got %v", fi.Mode())
+	}
+	if fi, err = os.Stat(filepath.Join(dir, "README")); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode 0600. got %v %v", fi, err)
+	}
+	if link, err := os.Readlink(filepath.Join(dir, "bin", "latest")); err != nil || link != "run" {
+		t.Fatalf("expected link to run. got %s %v", link, err)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	archives := map[string][]byte{
+		"tar":     newTar(t, testArchiveEntries),
+		"tar.gz":  newTarGz(t, testArchiveEntries),
+		"zip":     newZip(t, testArchiveEntries),
+		"tar.zst": newTarZst(t, testArchiveEntries),
+	}
+	for format, archive := range archives {
+		dir, filename := writeArchive(t, archive)
+		defer os.RemoveAll(dir)
+		files, err := nestor.Extract(context.Background(), filename, filepath.Join(dir, "out"), 1)
+		if err != nil {
+			t.Fatalf("expected nil for %s. got %v", format, err)
+		}
+		checkExtracted(t, filepath.Join(dir, "out"), files)
+	}
+}
+
+func TestExtractStrip(t *testing.T) {
+	dir, filename := writeArchive(t, newTarGz(t, testArchiveEntries))
+	defer os.RemoveAll(dir)
+	files, err := nestor.Extract(context.Background(), filename, filepath.Join(dir, "out"), 0)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if len(files) != 3 || files[0] != "app-1.0/bin/run" {
+		t.Fatalf("expected app-1.0/bin/run first. got %v", files)
+	}
+	files, err = nestor.Extract(context.Background(), filename, filepath.Join(dir, "stripped"), 2)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if !reflect.DeepEqual(files, []string{"run", "latest"}) {
+		t.Fatalf("expected [run latest]. got %v", files)
+	}
+}
+
+func TestExtractTraversal(t *testing.T) {
+	for _, entries := range [][]archiveEntry{
+		{{name: "../evil", body: "evil", mode: 0644, typeflag: tar.TypeReg}},
+		{{name: "app/../../evil", body: "evil", mode: 0644, typeflag: tar.TypeReg}},
+		{{name: "/evil", body: "evil", mode: 0644, typeflag: tar.TypeReg}},
+		{{name: "link", mode: 0777, typeflag: tar.TypeSymlink, linkname: "../"}},
+		{{name: "link", mode: 0777, typeflag: tar.TypeSymlink, linkname: "/tmp"}},
+		{{name: "link", mode: 0777, typeflag: tar.TypeLink, linkname: "../evil"}},
+		// chains of links only leaving the directory once the earlier links are followed
+		{
+			{name: "b", mode: 0777, typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "a", mode: 0777, typeflag: tar.TypeSymlink, linkname: "b/b/.."},
+			{name: "h", mode: 0644, typeflag: tar.TypeLink, linkname: "a/secret"},
+		},
+		{
+			{name: "b", mode: 0777, typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "evil", mode: 0777, typeflag: tar.TypeSymlink, linkname: "b/../evil"},
+		},
+		{
+			{name: "b", mode: 0777, typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "h", mode: 0644, typeflag: tar.TypeLink, linkname: "b/../secret"},
+		},
+	} {
+		for format, archive := range map[string][]byte{"tar.gz": newTarGz(t, entries), "zip": newZip(t, entries)} {
+			// zip archives have no hard links
+			if format == "zip" && entries[len(entries)-1].typeflag == tar.TypeLink {
+				continue
+			}
+			dir, filename := writeArchive(t, archive)
+			defer os.RemoveAll(dir)
+			if err := ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("outside"), 0644); err != nil {
+				t.Fatalf("expected nil. got %v", err)
+			}
+			out := filepath.Join(dir, "out")
+			if _, err := nestor.Extract(context.Background(), filename, out, 0); err == nil {
+				t.Fatalf("expected error for %s %s. got nil", format, entries[len(entries)-1].name)
+			}
+			if _, err := os.Lstat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+				t.Fatalf("expected no file outside of the extraction directory. got %v", err)
+			}
+			if b, err := ioutil.ReadFile(filepath.Join(out, "h")); err == nil {
+				t.Fatalf("expected no link to a file outside of the extraction directory. got %s", b)
+			}
+		}
+	}
+}
+
+func TestExtractThroughExistingLink(t *testing.T) {
+	for _, entries := range [][]archiveEntry{
+		{{name: "link/sub/evil", body: "evil", mode: 0644, typeflag: tar.TypeReg}},
+		{{name: "link/sub/", mode: 0755, typeflag: tar.TypeDir}},
+	} {
+		for format, archive := range map[string][]byte{"tar.gz": newTarGz(t, entries), "zip": newZip(t, entries)} {
+			dir, filename := writeArchive(t, archive)
+			defer os.RemoveAll(dir)
+			out := filepath.Join(dir, "out")
+			outside := filepath.Join(dir, "outside")
+			if err := os.MkdirAll(out, 0755); err != nil {
+				t.Fatalf("expected nil. got %v", err)
+			}
+			if err := os.Mkdir(outside, 0755); err != nil {
+				t.Fatalf("expected nil. got %v", err)
+			}
+			if err := os.Symlink(outside, filepath.Join(out, "link")); err != nil {
+				t.Fatalf("expected nil. got %v", err)
+			}
+			if _, err := nestor.Extract(context.Background(), filename, out, 0); err == nil {
+				t.Fatalf("expected error for %s %s. got nil", format, entries[0].name)
+			}
+			if _, err := os.Lstat(filepath.Join(outside, "sub")); !os.IsNotExist(err) {
+				t.Fatalf("expected no directory outside of the extraction directory for %s %s. got %v", format, entries[0].name, err)
+			}
+		}
+	}
+}
+
+func TestExtractNotAnArchive(t *testing.T) {
+	dir, filename := writeArchive(t, []byte("not an archive"))
+	defer os.RemoveAll(dir)
+	if _, err := nestor.Extract(context.Background(), filename, filepath.Join(dir, "out"), 0); err == nil {
+		t.Fatalf("expected error. got nil")
+	}
+}
+
+func TestDownloadExtract(t *testing.T) {
+	archive := newTarGz(t, testArchiveEntries)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer s.Close()
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "app")
+	res, err := executePoll(t, fmt.Sprintf(`download from "%s/app.tgz" save to "%s/app.tgz" extract to "%s" strip "1"`, s.URL, dir, out))
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	checkExtracted(t, out, res.Payload.(*nestor.DownloaderPayload).ExtractedFiles)
+}
@@ -1,62 +1,43 @@
 module github.com/jerminb/nestor
 
 require (
-	4d63.com/gochecknoglobals v0.0.0-20190306162314-7c3491d2b6ec // indirect
-	4d63.com/gochecknoinits v0.0.0-20180528051558-14d5915061e5 // indirect
 	cloud.google.com/go v0.45.1 // indirect
 	github.com/DATA-DOG/go-sqlmock v1.3.3
 	github.com/Jeffail/gabs v1.4.0 // indirect
-	github.com/alecthomas/gocyclo v0.0.0-20150208221726-aa8f8b160214 // indirect
-	github.com/alexkohler/nakedret v1.0.0 // indirect
 	github.com/cavaliercoder/grab v2.0.0+incompatible
-	github.com/go-kit/kit v0.9.0 // indirect
+	github.com/frankban/quicktest v1.14.6 // indirect
 	github.com/go-ldap/ldap v3.0.3+incompatible // indirect
 	github.com/go-test/deep v1.0.3 // indirect
 	github.com/gogo/protobuf v1.3.0 // indirect
-	github.com/google/go-cmp v0.3.1 // indirect
-	github.com/google/pprof v0.0.0-20190908185732-236ed259b199 // indirect
-	github.com/gordonklaus/ineffassign v0.0.0-20190601041439-ed7b1b5ee0f8 // indirect
 	github.com/hashicorp/go-memdb v1.0.3 // indirect
 	github.com/hashicorp/go-retryablehttp v0.6.2 // indirect
 	github.com/hashicorp/golang-lru v0.5.3 // indirect
 	github.com/hashicorp/vault v1.2.2
 	github.com/hashicorp/vault/api v1.0.5-0.20190814205542-3b036e58e950
-	github.com/jasonlvhit/gocron v0.0.0-20191125235832-30e323a962ed
+	github.com/hashicorp/vault/sdk v0.1.14-0.20190814205504-1cad00d1133b
 	github.com/jefferai/jsonx v1.0.1 // indirect
-	github.com/jgautheron/goconst v0.0.0-20170703170152-9740945f5dcb // indirect
 	github.com/keybase/go-crypto v0.0.0-20190828182435-a05457805304 // indirect
+	github.com/klauspost/compress v1.13.4
 	github.com/konsorten/go-windows-terminal-sequences v1.0.2 // indirect
-	github.com/mattn/go-colorable v0.1.2 // indirect
-	github.com/mattn/go-isatty v0.0.9 // indirect
-	github.com/mdempsky/maligned v0.0.0-20180708014732-6e39bd26a8c8 // indirect
-	github.com/mdempsky/unconvert v0.0.0-20190921185256-3ecd357795af // indirect
-	github.com/mibk/dupl v1.0.0 // indirect
+	github.com/lib/pq v1.2.0 // indirect
 	github.com/mitchellh/reflectwalk v1.0.1 // indirect
-	github.com/mwitkow/go-conntrack v0.0.0-20190716064945-2f068394615f // indirect
-	github.com/opennota/check v0.0.0-20180911053232-0c771f5545ff // indirect
+	github.com/onsi/ginkgo v1.10.3 // indirect
+	github.com/onsi/gomega v1.7.1 // indirect
 	github.com/pierrec/lz4 v2.3.0+incompatible // indirect
 	github.com/prometheus/client_golang v1.1.0 // indirect
 	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4 // indirect
 	github.com/prometheus/procfs v0.0.4 // indirect
-	github.com/securego/gosec v0.0.0-20191119104125-df484bfa9e9f // indirect
 	github.com/sirupsen/logrus v1.4.2
-	github.com/stripe/safesql v0.2.0 // indirect
-	github.com/tsenart/deadcode v0.0.0-20160724212837-210d2dc333e9 // indirect
-	github.com/walle/lll v1.0.1 // indirect
+	github.com/stretchr/testify v1.4.0 // indirect
 	go.etcd.io/bbolt v1.3.3 // indirect
 	go.opencensus.io v0.22.1 // indirect
-	golang.org/x/exp v0.0.0-20190829153037-c13cbed26979 // indirect
-	golang.org/x/image v0.0.0-20190902063713-cb417be4ba39 // indirect
-	golang.org/x/mobile v0.0.0-20190830201351-c6da95954960 // indirect
+	golang.org/x/crypto v0.0.0-20190923035154-9ee001bba392 // indirect
+	golang.org/x/net v0.0.0-20190923162816-aa69164e4478 // indirect
 	google.golang.org/api v0.10.0 // indirect
 	google.golang.org/appengine v1.6.2 // indirect
 	google.golang.org/genproto v0.0.0-20190905072037-92dd089d5514 // indirect
 	google.golang.org/grpc v1.23.0 // indirect
-	gopkg.in/h2non/gock.v1 v1.0.15
-	honnef.co/go/tools v0.0.1-2019.2.3 // indirect
-	mvdan.cc/interfacer v0.0.0-20180901003855-c20040233aed // indirect
-	mvdan.cc/lint v0.0.0-20170908181259-adc824a0674b // indirect
-	mvdan.cc/unparam v0.0.0-20191111180625-960b1ec0f2c2 // indirect
+	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 )
 
 go 1.13
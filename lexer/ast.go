@@ -18,22 +18,78 @@ type Statement interface {
 // for common properties
 type BaseStatement struct {
 	IsBackground bool
+	// Timeout is the maximum duration of the statement, empty for no timeout
+	Timeout string
+	// Schedule is a cron expression or @every duration the statement is repeated on, empty to run once
+	Schedule string
+	// AtStartup runs a scheduled statement once at startup before its first scheduled run
+	AtStartup bool
+	// MissedRun is SKIP or ONCE to choose what happens to activations missed by a long run,
+	// ILLEGAL to leave it to the scheduler
+	MissedRun Token
 }
 
+//Base returns the BaseStatement of a statement
+func (b *BaseStatement) Base() *BaseStatement {
+	return b
+}
+
+// String returns the common statement suffix, including its leading space.
 func (b *BaseStatement) String() string {
+	var buf bytes.Buffer
+	if b.AtStartup {
+		_, _ = buf.WriteString(" AT STARTUP")
+	}
+	if b.Schedule != "" {
+		_, _ = buf.WriteString(" SCHEDULE ")
+		_, _ = buf.WriteString(Quote(b.Schedule))
+		if b.MissedRun != ILLEGAL {
+			_, _ = buf.WriteString(" ON MISSED ")
+			_, _ = buf.WriteString(b.MissedRun.String())
+		}
+	}
+	if b.Timeout != "" {
+		_, _ = buf.WriteString(" TIMEOUT ")
+		_, _ = buf.WriteString(Quote(b.Timeout))
+	}
 	if b.IsBackground {
-		return "&"
+		_, _ = buf.WriteString(" &")
 	}
-	return ""
+	return buf.String()
+}
+
+// Quote returns s as a string literal that scans back to s.
+// String methods use it so that printed statements can be parsed again.
+func Quote(s string) string {
+	var buf bytes.Buffer
+	_ = buf.WriteByte('"')
+	for _, ch := range s {
+		switch ch {
+		case '"', '\\':
+			_ = buf.WriteByte('\\')
+			_, _ = buf.WriteRune(ch)
+		case '\n':
+			_, _ = buf.WriteString(`\n`)
+		default:
+			_, _ = buf.WriteRune(ch)
+		}
+	}
+	_ = buf.WriteByte('"')
+	return buf.String()
 }
 
 // Statements represents a list of statements.
 type Statements []Statement
 
 // String returns a string representation of the statements.
+// Nested queries are enclosed in parentheses followed by their own clauses.
 func (a Statements) String() string {
 	var str []string
 	for _, stmt := range a {
+		if q, ok := stmt.(*Query); ok {
+			str = append(str, "("+q.String()+")"+q.BaseStatement.String())
+			continue
+		}
 		str = append(str, stmt.String())
 	}
 	return strings.Join(str, ";\n")
@@ -72,6 +128,7 @@ func (*PollStatement) node()       {}
 func (*DownloadStatement) node()   {}
 func (*SQLExecuteStatement) node() {}
 func (*RefreshStatement) node()    {}
+func (*SetStatement) node()        {}
 
 func (*Query) stmt() {}
 
@@ -80,6 +137,7 @@ func (*PollStatement) stmt()       {}
 func (*DownloadStatement) stmt()   {}
 func (*SQLExecuteStatement) stmt() {}
 func (*RefreshStatement) stmt()    {}
+func (*SetStatement) stmt()        {}
 
 // PollStatement represents a command for polling an endpoint.
 type PollStatement struct {
@@ -89,29 +147,183 @@ type PollStatement struct {
 	URL             string
 	InitialWaitTime string
 	MaxRetryCount   string
+	// Expectations must all be met by a response for the poll to succeed
+	Expectations []*Expectation
+	Request      RequestOptions
+	// Backoff replaces the fixed polling interval when set
+	Backoff *Backoff
+	// Deadline is the duration after which polling gives up
+	Deadline string
+	// URLs are the endpoints polled along with URL
+	URLs []string
+	// Quorum is ALL, ANY or the count of endpoints that must succeed. It defaults to ALL.
+	Quorum string
+	// UntilDown inverts the poll; it succeeds once the endpoint is down for DownCount consecutive polls
+	UntilDown bool
+	DownCount string
+}
+
+// Endpoints returns URL followed by URLs.
+func (p *PollStatement) Endpoints() []string {
+	return append([]string{p.URL}, p.URLs...)
+}
+
+// Backoff represents a BACKOFF FIXED, BACKOFF EXPONENTIAL or BACKOFF JITTER clause.
+// Max caps the growing delays of EXPONENTIAL and JITTER.
+type Backoff struct {
+	Policy Token
+	Max    string
+}
+
+// String returns a string representation of the backoff.
+func (b *Backoff) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("BACKOFF ")
+	_, _ = buf.WriteString(b.Policy.String())
+	if b.Max != "" {
+		_, _ = buf.WriteString(" MAX ")
+		_, _ = buf.WriteString(Quote(b.Max))
+	}
+	return buf.String()
+}
+
+// RequestOptions represents the METHOD, HEADER, AUTH and BODY clauses of a statement sending HTTP requests.
+type RequestOptions struct {
+	Method  string
+	Headers []*Header
+	Auth    *Auth
+	Body    string
+}
+
+// Header represents a HEADER "name" "value" clause.
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Auth represents an AUTH BASIC "user" "password" or AUTH BEARER "token" clause.
+type Auth struct {
+	Scheme   Token
+	Username string
+	Password string
+	Token    string
+}
+
+// String returns a string representation of the request options, with a leading space
+// when any option is set.
+func (r *RequestOptions) String() string {
+	var buf bytes.Buffer
+	if r.Method != "" {
+		_, _ = buf.WriteString(" METHOD ")
+		_, _ = buf.WriteString(Quote(r.Method))
+	}
+	for _, h := range r.Headers {
+		_, _ = buf.WriteString(" HEADER ")
+		_, _ = buf.WriteString(Quote(h.Name))
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(Quote(h.Value))
+	}
+	if r.Auth != nil {
+		_, _ = buf.WriteString(" AUTH ")
+		_, _ = buf.WriteString(r.Auth.Scheme.String())
+		_, _ = buf.WriteString(" ")
+		if r.Auth.Scheme == BASIC {
+			_, _ = buf.WriteString(Quote(r.Auth.Username))
+			_, _ = buf.WriteString(" ")
+			_, _ = buf.WriteString(Quote(r.Auth.Password))
+		} else {
+			_, _ = buf.WriteString(Quote(r.Auth.Token))
+		}
+	}
+	if r.Body != "" {
+		_, _ = buf.WriteString(" BODY ")
+		_, _ = buf.WriteString(Quote(r.Body))
+	}
+	return buf.String()
+}
+
+// Expectation represents an EXPECT condition on a polled response.
+// Subject is STATUS, BODY or JSON and Path is the JSON path of a JSON expectation.
+type Expectation struct {
+	Subject  Token
+	Path     string
+	Operator Token
+	Value    string
+}
+
+// String returns a string representation of the expectation.
+func (e *Expectation) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("EXPECT ")
+	_, _ = buf.WriteString(e.Subject.String())
+	if e.Subject == JSON {
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(Quote(e.Path))
+	}
+	_, _ = buf.WriteString(" ")
+	_, _ = buf.WriteString(e.Operator.String())
+	_, _ = buf.WriteString(" ")
+	_, _ = buf.WriteString(Quote(e.Value))
+	return buf.String()
 }
 
 // String returns a string representation of the poll statement.
 func (p *PollStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("POLL ")
-	_, _ = buf.WriteString(p.URL)
+	_, _ = buf.WriteString(Quote(p.URL))
+	for _, u := range p.URLs {
+		_, _ = buf.WriteString(", ")
+		_, _ = buf.WriteString(Quote(u))
+	}
+	switch p.Quorum {
+	case "":
+	case ALL.String(), ANY.String():
+		_, _ = buf.WriteString(" QUORUM ")
+		_, _ = buf.WriteString(p.Quorum)
+	default:
+		_, _ = buf.WriteString(" QUORUM ")
+		_, _ = buf.WriteString(Quote(p.Quorum))
+	}
+	_, _ = buf.WriteString(p.Request.String())
 	_, _ = buf.WriteString(" EVERY ")
-	_, _ = buf.WriteString(p.Interval)
+	_, _ = buf.WriteString(Quote(p.Interval))
 
 	if p.InitialWaitTime != "" {
 		_, _ = buf.WriteString(" AFTER ")
-		_, _ = buf.WriteString(p.InitialWaitTime)
+		_, _ = buf.WriteString(Quote(p.InitialWaitTime))
 	}
 
 	_, _ = buf.WriteString(" ")
-	_, _ = buf.WriteString(p.MaxRetryCount)
+	_, _ = buf.WriteString(Quote(p.MaxRetryCount))
 	_, _ = buf.WriteString(" TIMES")
 
-	if p.IsBackground {
-		_, _ = buf.WriteString(" &")
+	if p.Backoff != nil {
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(p.Backoff.String())
 	}
 
+	if p.Deadline != "" {
+		_, _ = buf.WriteString(" DEADLINE ")
+		_, _ = buf.WriteString(Quote(p.Deadline))
+	}
+
+	if p.UntilDown {
+		_, _ = buf.WriteString(" UNTIL DOWN")
+		if p.DownCount != "" {
+			_, _ = buf.WriteString(" ")
+			_, _ = buf.WriteString(Quote(p.DownCount))
+			_, _ = buf.WriteString(" TIMES")
+		}
+	}
+
+	for _, e := range p.Expectations {
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(e.String())
+	}
+
+	_, _ = buf.WriteString(p.BaseStatement.String())
+
 	return buf.String()
 }
 
@@ -120,19 +332,133 @@ type DownloadStatement struct {
 	BaseStatement
 	URL      string
 	FilePath string
+	// Request holds the headers and credentials sent with the download. METHOD and BODY are not used.
+	Request RequestOptions
+	// Verifications are checked once the file is downloaded
+	Verifications []*Verification
+	// Extract unpacks the verified file when set
+	Extract *Extraction
+	// Mirrors are tried in order when URL fails, or ordered by response time when FastestFirst is set
+	Mirrors      []string
+	FastestFirst bool
+	// All is set by DOWNLOAD ALL, which saves the files of URLs, and of the urls listed in the
+	// Manifest file, into the directory FilePath. URL is not used.
+	All      bool
+	URLs     []string
+	Manifest string
+	// ContinueOnError keeps the other files of DOWNLOAD ALL downloading when one fails
+	ContinueOnError bool
+}
+
+// Sources returns URL followed by the mirrors of the download.
+func (d *DownloadStatement) Sources() []string {
+	return append([]string{d.URL}, d.Mirrors...)
+}
+
+// Extraction represents an EXTRACT TO clause unpacking a downloaded archive into Directory.
+// StripComponents is the number of leading path elements removed from every entry.
+type Extraction struct {
+	Directory       string
+	StripComponents string
+}
+
+// String returns a string representation of the extraction.
+func (e *Extraction) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("EXTRACT TO ")
+	_, _ = buf.WriteString(Quote(e.Directory))
+	if e.StripComponents != "" {
+		_, _ = buf.WriteString(" STRIP ")
+		_, _ = buf.WriteString(Quote(e.StripComponents))
+	}
+	return buf.String()
+}
+
+// Verification represents a VERIFY SHA256, VERIFY SHA512 or VERIFY SIGNATURE clause.
+// Checksum is the expected hex digest. From is the url of a sidecar file holding the digest
+// or the signature.
+type Verification struct {
+	Algorithm Token
+	Checksum  string
+	From      string
+}
+
+// String returns a string representation of the verification.
+func (v *Verification) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("VERIFY ")
+	_, _ = buf.WriteString(v.Algorithm.String())
+	if v.From != "" {
+		_, _ = buf.WriteString(" FROM ")
+		_, _ = buf.WriteString(Quote(v.From))
+	} else {
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(Quote(v.Checksum))
+	}
+	return buf.String()
 }
 
 // String returns a string representation of the download statement.
 func (d *DownloadStatement) String() string {
+	if d.All {
+		return d.allString()
+	}
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("DOWNLOAD FROM ")
-	_, _ = buf.WriteString(d.URL)
+	_, _ = buf.WriteString(Quote(d.URL))
+	for _, m := range d.Mirrors {
+		_, _ = buf.WriteString(" OR ")
+		_, _ = buf.WriteString(Quote(m))
+	}
+	if d.FastestFirst {
+		_, _ = buf.WriteString(" FASTEST")
+	}
 	_, _ = buf.WriteString(" SAVE TO ")
-	_, _ = buf.WriteString(d.FilePath)
+	_, _ = buf.WriteString(Quote(d.FilePath))
+	if opts := d.Request.String(); opts != "" {
+		_, _ = buf.WriteString(" WITH")
+		_, _ = buf.WriteString(opts)
+	}
 
-	if d.IsBackground {
-		_, _ = buf.WriteString(" &")
+	for _, v := range d.Verifications {
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(v.String())
+	}
+	if d.Extract != nil {
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(d.Extract.String())
+	}
+
+	_, _ = buf.WriteString(d.BaseStatement.String())
+	return buf.String()
+}
+
+// allString returns the string representation of a DOWNLOAD ALL statement.
+func (d *DownloadStatement) allString() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("DOWNLOAD ALL FROM ")
+	if d.Manifest != "" {
+		_, _ = buf.WriteString("MANIFEST ")
+		_, _ = buf.WriteString(Quote(d.Manifest))
+	} else {
+		quoted := make([]string, len(d.URLs))
+		for i, u := range d.URLs {
+			quoted[i] = Quote(u)
+		}
+		_, _ = buf.WriteString("(")
+		_, _ = buf.WriteString(strings.Join(quoted, ", "))
+		_, _ = buf.WriteString(")")
+	}
+	_, _ = buf.WriteString(" SAVE TO ")
+	_, _ = buf.WriteString(Quote(d.FilePath))
+	if opts := d.Request.String(); opts != "" {
+		_, _ = buf.WriteString(" WITH")
+		_, _ = buf.WriteString(opts)
+	}
+	if d.ContinueOnError {
+		_, _ = buf.WriteString(" ON ERROR CONTINUE")
 	}
+	_, _ = buf.WriteString(d.BaseStatement.String())
 	return buf.String()
 }
 
@@ -147,12 +473,10 @@ type SQLExecuteStatement struct {
 func (s *SQLExecuteStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("SQLEXECUTE FROM ")
-	_, _ = buf.WriteString(s.FilePath)
+	_, _ = buf.WriteString(Quote(s.FilePath))
 	_, _ = buf.WriteString(" INTO ")
-	_, _ = buf.WriteString(s.DBConnectionString)
-	if s.IsBackground {
-		_, _ = buf.WriteString(" &")
-	}
+	_, _ = buf.WriteString(Quote(s.DBConnectionString))
+	_, _ = buf.WriteString(s.BaseStatement.String())
 	return buf.String()
 }
 
@@ -164,6 +488,8 @@ type RefreshStatement struct {
 	Path     string
 	// Refresh internval
 	Interval string
+	// Destination is the directory refreshed certificates are written to
+	Destination string
 }
 
 // String returns a string representation of the refresh statement.
@@ -172,11 +498,31 @@ func (r *RefreshStatement) String() string {
 	_, _ = buf.WriteString("REFRESH ")
 	_, _ = buf.WriteString(r.Artifact)
 	_, _ = buf.WriteString(" FROM ")
-	_, _ = buf.WriteString(r.Path)
+	_, _ = buf.WriteString(Quote(r.Path))
 	_, _ = buf.WriteString(" EVERY ")
-	_, _ = buf.WriteString(r.Interval)
-	if r.IsBackground {
-		_, _ = buf.WriteString(" &")
+	_, _ = buf.WriteString(Quote(r.Interval))
+	if r.Destination != "" {
+		_, _ = buf.WriteString(" SAVE TO ")
+		_, _ = buf.WriteString(Quote(r.Destination))
 	}
+	_, _ = buf.WriteString(r.BaseStatement.String())
+	return buf.String()
+}
+
+//SetStatement represents a variable assignment. Variables are referenced as ${name}
+// in the literals of the statements that follow it.
+type SetStatement struct {
+	BaseStatement
+	Name  string
+	Value string
+}
+
+// String returns a string representation of the set statement.
+func (s *SetStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("SET ")
+	_, _ = buf.WriteString(s.Name)
+	_, _ = buf.WriteString(" = ")
+	_, _ = buf.WriteString(Quote(s.Value))
 	return buf.String()
 }
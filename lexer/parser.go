@@ -12,6 +12,7 @@ type Parser struct {
 	buf struct {
 		tok Token  // last read token
 		lit string // last read literal
+		pos Pos    // last read position
 		n   int    // buffer size (max=1)
 	}
 }
@@ -34,7 +35,7 @@ func (p *Parser) scan() (tok Token, lit string) {
 	tok, lit = p.s.Scan()
 
 	// Save it to the buffer in case we unscan later.
-	p.buf.tok, p.buf.lit = tok, lit
+	p.buf.tok, p.buf.lit, p.buf.pos = tok, lit, p.s.Pos()
 
 	return
 }
@@ -51,6 +52,89 @@ func (p *Parser) scanIgnoreWhitespace() (tok Token, lit string) {
 	return
 }
 
+// scanValue scans the next non-whitespace token as a value. Contextual keywords are returned as IDENT
+// so that the words of the clauses added to the language remain valid unquoted values, e.g. SAVE TO body.
+func (p *Parser) scanValue() (tok Token, lit string) {
+	tok, lit = p.scanIgnoreWhitespace()
+	if tok.isContextual() {
+		tok = IDENT
+	}
+	return
+}
+
+// ScanIgnoreWhitespace returns the next non-whitespace token for the parse function of a registered statement.
+func (p *Parser) ScanIgnoreWhitespace() (tok Token, lit string) {
+	return p.scanIgnoreWhitespace()
+}
+
+// Unscan pushes the previously read token back so that it is returned by the next scan.
+func (p *Parser) Unscan() { p.unscan() }
+
+// NewParseError returns a ParseError at the position of the last read token.
+func (p *Parser) NewParseError(found string, expected []string) *ParseError {
+	return p.newParseError(found, expected)
+}
+
+// ParseBaseStatement parses the TIMEOUT and background clauses at the end of a registered statement.
+func (p *Parser) ParseBaseStatement(b *BaseStatement) error {
+	return p.parseBaseStatement(b)
+}
+
+// parseBaseStatement parses the optional clauses shared by all statements:
+// AT STARTUP, SCHEDULE and TIMEOUT in any order followed by the background flag.
+// A SCHEDULE clause may end with ON MISSED SKIP or ON MISSED ONCE.
+func (p *Parser) parseBaseStatement(b *BaseStatement) error {
+	for {
+		tok, lit := p.scanIgnoreWhitespace()
+		switch {
+		case tok == AT && !b.AtStartup:
+			if tok, lit := p.scanIgnoreWhitespace(); tok != STARTUP {
+				return p.newParseError(Tokstr(tok, lit), []string{"STARTUP"})
+			}
+			b.AtStartup = true
+		case tok == SCHEDULE && b.Schedule == "":
+			tok, lit := p.scanValue()
+			if tok != IDENT || lit == "" {
+				return p.newParseError(Tokstr(tok, lit), []string{"Schedule"})
+			}
+			b.Schedule = lit
+			if err := p.parseMissedRun(b); err != nil {
+				return err
+			}
+		case tok == TIMEOUT && b.Timeout == "":
+			tok, lit := p.scanValue()
+			if tok != IDENT {
+				return p.newParseError(Tokstr(tok, lit), []string{"Timeout"})
+			}
+			b.Timeout = lit
+		case tok == AT || tok == SCHEDULE || tok == TIMEOUT:
+			return p.newParseError(Tokstr(tok, lit), []string{"a single " + tok.String() + " clause"})
+		default:
+			p.unscan()
+			b.IsBackground = p.scanAmpersand()
+			return nil
+		}
+	}
+}
+
+// parseMissedRun parses the optional ON MISSED SKIP or ON MISSED ONCE of a SCHEDULE clause.
+func (p *Parser) parseMissedRun(b *BaseStatement) error {
+	if tok, _ := p.scanIgnoreWhitespace(); tok != ON {
+		p.unscan()
+		return nil
+	}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != MISSED {
+		return p.newParseError(Tokstr(tok, lit), []string{"MISSED"})
+	}
+	switch tok, lit := p.scanIgnoreWhitespace(); tok {
+	case SKIP, ONCE:
+		b.MissedRun = tok
+	default:
+		return p.newParseError(Tokstr(tok, lit), []string{"SKIP", "ONCE"})
+	}
+	return nil
+}
+
 func (p *Parser) scanAmpersand() bool {
 	tok, _ := p.scanIgnoreWhitespace()
 	if tok != AMPERSAND {
@@ -67,34 +151,65 @@ func (p *Parser) parsePollStatement() (*PollStatement, error) {
 
 	// First token should be a "POLL" keyword.
 	if tok, lit := p.scanIgnoreWhitespace(); tok != POLL {
-		return nil, newParseError(Tokstr(tok, lit), []string{"POLL"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"POLL"})
 	}
 
 	// Next we should read a URL.
-	tok, lit := p.scanIgnoreWhitespace()
+	tok, lit := p.scanValue()
 	if tok != IDENT {
-		return nil, newParseError(Tokstr(tok, lit), []string{"URL"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"URL"})
 	}
 	stmt.URL = lit
 
+	// Then any number of comma separated URLs and an optional QUORUM.
+	for {
+		if tok, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+		u, err := p.parseIdent("URL")
+		if err != nil {
+			return nil, err
+		}
+		stmt.URLs = append(stmt.URLs, u)
+	}
+	if tok, _ := p.scanIgnoreWhitespace(); tok == QUORUM {
+		tok, lit := p.scanIgnoreWhitespace()
+		switch tok {
+		case ALL, ANY:
+			stmt.Quorum = tok.String()
+		case IDENT:
+			stmt.Quorum = lit
+		default:
+			return nil, p.newParseError(Tokstr(tok, lit), []string{"ALL", "ANY", "Quorum"})
+		}
+	} else {
+		p.unscan()
+	}
+
+	// Then the optional request options.
+	if err := p.parseRequestOptions(&stmt.Request); err != nil {
+		return nil, err
+	}
+
 	// Next we should see the "EVERY" keyword.
 	if tok, lit := p.scanIgnoreWhitespace(); tok != EVERY {
-		return nil, newParseError(Tokstr(tok, lit), []string{"EVERY"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"EVERY"})
 	}
 
 	// Next we should read polling interval.
-	tok, lit = p.scanIgnoreWhitespace()
+	tok, lit = p.scanValue()
 	if tok != IDENT {
-		return nil, newParseError(Tokstr(tok, lit), []string{"PollingInterval"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"PollingInterval"})
 	}
 	stmt.Interval = lit
 
 	// If the next token is an After then look for InitialWaitTime.
 	tok, _ = p.scanIgnoreWhitespace()
 	if tok == AFTER {
-		tokafter, litafter := p.scanIgnoreWhitespace()
+		tokafter, litafter := p.scanValue()
 		if tokafter != IDENT {
-			return nil, newParseError(Tokstr(tokafter, litafter), []string{"InitialWaitTime"})
+			return nil, p.newParseError(Tokstr(tokafter, litafter), []string{"InitialWaitTime"})
 		}
 		stmt.InitialWaitTime = litafter
 	} else {
@@ -102,23 +217,314 @@ func (p *Parser) parsePollStatement() (*PollStatement, error) {
 	}
 
 	// Next we should read MaxRetryCount.
-	tok, lit = p.scanIgnoreWhitespace()
+	tok, lit = p.scanValue()
 	if tok != IDENT {
-		return nil, newParseError(Tokstr(tok, lit), []string{"MaxRetryCount"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"MaxRetryCount"})
 	}
 	stmt.MaxRetryCount = lit
 
 	// Next we should see the "TIMES" keyword.
 	if tok, lit := p.scanIgnoreWhitespace(); tok != TIMES {
-		return nil, newParseError(Tokstr(tok, lit), []string{"TIMES"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"TIMES"})
+	}
+
+	// Then any number of EXPECT conditions and an optional BACKOFF, DEADLINE and UNTIL DOWN, in any order.
+	if err := p.parsePollOptions(stmt); err != nil {
+		return nil, err
 	}
 
-	stmt.IsBackground = p.scanAmpersand()
+	if err := p.parseBaseStatement(&stmt.BaseStatement); err != nil {
+		return nil, err
+	}
 
 	// Return the successfully parsed statement.
 	return stmt, nil
 }
 
+// parsePollOptions parses the EXPECT, BACKOFF, DEADLINE and UNTIL DOWN clauses following TIMES.
+func (p *Parser) parsePollOptions(stmt *PollStatement) error {
+	for {
+		tok, lit := p.scanIgnoreWhitespace()
+		switch tok {
+		case EXPECT:
+			e, err := p.parseExpectation()
+			if err != nil {
+				return err
+			}
+			stmt.Expectations = append(stmt.Expectations, e)
+		case BACKOFF:
+			if stmt.Backoff != nil {
+				return p.newParseError(Tokstr(tok, lit), []string{"a single " + tok.String() + " clause"})
+			}
+			b, err := p.parseBackoff()
+			if err != nil {
+				return err
+			}
+			stmt.Backoff = b
+		case DEADLINE:
+			if stmt.Deadline != "" {
+				return p.newParseError(Tokstr(tok, lit), []string{"a single " + tok.String() + " clause"})
+			}
+			deadline, err := p.parseIdent("Deadline")
+			if err != nil {
+				return err
+			}
+			stmt.Deadline = deadline
+		case UNTIL:
+			if stmt.UntilDown {
+				return p.newParseError(Tokstr(tok, lit), []string{"a single " + tok.String() + " clause"})
+			}
+			if err := p.parseUntilDown(stmt); err != nil {
+				return err
+			}
+		default:
+			p.unscan()
+			return nil
+		}
+	}
+}
+
+// parseUntilDown parses DOWN and the optional "count" TIMES following an UNTIL keyword.
+func (p *Parser) parseUntilDown(stmt *PollStatement) error {
+	if tok, lit := p.scanIgnoreWhitespace(); tok != DOWN {
+		return p.newParseError(Tokstr(tok, lit), []string{"DOWN"})
+	}
+	stmt.UntilDown = true
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != IDENT {
+		p.unscan()
+		return nil
+	}
+	stmt.DownCount = lit
+	if tok, lit := p.scanIgnoreWhitespace(); tok != TIMES {
+		return p.newParseError(Tokstr(tok, lit), []string{"TIMES"})
+	}
+	return nil
+}
+
+// parseBackoff parses the policy following a BACKOFF keyword. EXPONENTIAL and JITTER
+// may be followed by MAX "duration".
+func (p *Parser) parseBackoff() (*Backoff, error) {
+	tok, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case FIXED:
+		return &Backoff{Policy: tok}, nil
+	case EXPONENTIAL, JITTER:
+	default:
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"FIXED", "EXPONENTIAL", "JITTER"})
+	}
+	b := &Backoff{Policy: tok}
+	if tok, _ := p.scanIgnoreWhitespace(); tok != MAX {
+		p.unscan()
+		return b, nil
+	}
+	max, err := p.parseIdent("MaxBackoff")
+	if err != nil {
+		return nil, err
+	}
+	b.Max = max
+	return b, nil
+}
+
+// parseVerification parses the condition following a VERIFY keyword; SHA256 or SHA512 followed by
+// a checksum or FROM "url", or SIGNATURE FROM "url".
+func (p *Parser) parseVerification() (*Verification, error) {
+	tok, lit := p.scanIgnoreWhitespace()
+	v := &Verification{Algorithm: tok}
+	switch tok {
+	case SHA256, SHA512, SIGNATURE:
+	default:
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"SHA256", "SHA512", "SIGNATURE"})
+	}
+	tok, lit = p.scanIgnoreWhitespace()
+	switch {
+	case tok == FROM:
+		from, err := p.parseIdent("URL")
+		if err != nil {
+			return nil, err
+		}
+		v.From = from
+	case (tok == IDENT || tok.isContextual()) && v.Algorithm != SIGNATURE:
+		v.Checksum = lit
+	case v.Algorithm == SIGNATURE:
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"FROM"})
+	default:
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"Checksum", "FROM"})
+	}
+	return v, nil
+}
+
+// parseExtraction parses the directory and optional STRIP count following an EXTRACT keyword.
+func (p *Parser) parseExtraction() (*Extraction, error) {
+	if tok, lit := p.scanIgnoreWhitespace(); tok != TO {
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"TO"})
+	}
+	dir, err := p.parseIdent("DIRECTORY")
+	if err != nil {
+		return nil, err
+	}
+	e := &Extraction{Directory: dir}
+	if tok, _ := p.scanIgnoreWhitespace(); tok != STRIP {
+		p.unscan()
+		return e, nil
+	}
+	if e.StripComponents, err = p.parseIdent("StripComponents"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// parseRequestOptions parses METHOD "verb", HEADER "name" "value", AUTH BASIC "user" "password",
+// AUTH BEARER "token" and BODY "payload" clauses in any order. HEADER may be repeated.
+func (p *Parser) parseRequestOptions(r *RequestOptions) error {
+	for {
+		tok, lit := p.scanIgnoreWhitespace()
+		switch tok {
+		case METHOD:
+			if r.Method != "" {
+				return p.newParseError(Tokstr(tok, lit), []string{"a single " + tok.String() + " clause"})
+			}
+			method, err := p.parseIdent("Method")
+			if err != nil {
+				return err
+			}
+			r.Method = method
+		case HEADER, AUTH:
+			if err := p.parseCredentials(tok, lit, r); err != nil {
+				return err
+			}
+		case BODY:
+			if r.Body != "" {
+				return p.newParseError(Tokstr(tok, lit), []string{"a single " + tok.String() + " clause"})
+			}
+			body, err := p.parseIdent("Body")
+			if err != nil {
+				return err
+			}
+			r.Body = body
+		default:
+			p.unscan()
+			return nil
+		}
+	}
+}
+
+// parseDownloadRequestOptions parses HEADER "name" "value", AUTH BASIC "user" "password" and
+// AUTH BEARER "token" clauses in any order, each optionally preceded by WITH.
+func (p *Parser) parseDownloadRequestOptions(r *RequestOptions) error {
+	for {
+		tok, lit := p.scanIgnoreWhitespace()
+		if tok == WITH {
+			if tok, lit = p.scanIgnoreWhitespace(); tok != HEADER && tok != AUTH {
+				return p.newParseError(Tokstr(tok, lit), []string{"HEADER", "AUTH"})
+			}
+		}
+		if tok != HEADER && tok != AUTH {
+			p.unscan()
+			return nil
+		}
+		if err := p.parseCredentials(tok, lit, r); err != nil {
+			return err
+		}
+	}
+}
+
+// parseCredentials parses the clause following a HEADER or AUTH keyword into r.
+func (p *Parser) parseCredentials(tok Token, lit string, r *RequestOptions) error {
+	if tok == AUTH {
+		if r.Auth != nil {
+			return p.newParseError(Tokstr(tok, lit), []string{"a single " + tok.String() + " clause"})
+		}
+		auth, err := p.parseAuth()
+		if err != nil {
+			return err
+		}
+		r.Auth = auth
+		return nil
+	}
+	name, err := p.parseIdent("HeaderName")
+	if err != nil {
+		return err
+	}
+	value, err := p.parseIdent("HeaderValue")
+	if err != nil {
+		return err
+	}
+	r.Headers = append(r.Headers, &Header{Name: name, Value: value})
+	return nil
+}
+
+// parseAuth parses the scheme and credentials following an AUTH keyword.
+func (p *Parser) parseAuth() (*Auth, error) {
+	tok, lit := p.scanIgnoreWhitespace()
+	auth := &Auth{Scheme: tok}
+	var err error
+	switch tok {
+	case BASIC:
+		if auth.Username, err = p.parseIdent("Username"); err != nil {
+			return nil, err
+		}
+		if auth.Password, err = p.parseIdent("Password"); err != nil {
+			return nil, err
+		}
+	case BEARER:
+		if auth.Token, err = p.parseIdent("Token"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"BASIC", "BEARER"})
+	}
+	return auth, nil
+}
+
+// parseIdent parses a literal. name is the expected literal in the error returned for any other token.
+func (p *Parser) parseIdent(name string) (string, error) {
+	tok, lit := p.scanValue()
+	if tok != IDENT {
+		return "", p.newParseError(Tokstr(tok, lit), []string{name})
+	}
+	return lit, nil
+}
+
+// parseExpectation parses the condition following an EXPECT keyword.
+// The operator of a STATUS expectation is optional and defaults to =.
+func (p *Parser) parseExpectation() (*Expectation, error) {
+	e := &Expectation{}
+	tok, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case STATUS, BODY:
+	case JSON:
+		pathTok, path := p.scanValue()
+		if pathTok != IDENT || path == "" {
+			return nil, p.newParseError(Tokstr(pathTok, path), []string{"JSONPATH"})
+		}
+		e.Path = path
+	default:
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"STATUS", "BODY", "JSON"})
+	}
+	e.Subject = tok
+
+	tok, lit = p.scanIgnoreWhitespace()
+	switch tok {
+	case EQ, NEQ, EQREGEX, NEQREGEX:
+		e.Operator = tok
+		tok, lit = p.scanIgnoreWhitespace()
+	default:
+		if e.Subject != STATUS {
+			return nil, p.newParseError(Tokstr(tok, lit), []string{"=", "!=", "=~", "!~"})
+		}
+		e.Operator = EQ
+	}
+	if tok.isContextual() {
+		tok = IDENT
+	}
+	if tok != IDENT {
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"VALUE"})
+	}
+	e.Value = lit
+	return e, nil
+}
+
 // parseDownloadStatement parses a DOWNLOAD statement.
 func (p *Parser) parseDownloadStatement() (*DownloadStatement, error) {
 	stmt := &DownloadStatement{}
@@ -126,44 +532,179 @@ func (p *Parser) parseDownloadStatement() (*DownloadStatement, error) {
 
 	// First token should be a "DOWNLOAD" keyword.
 	if tok, lit := p.scanIgnoreWhitespace(); tok != DOWNLOAD {
-		return nil, newParseError(Tokstr(tok, lit), []string{"DOWNLOAD"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"DOWNLOAD"})
 	}
 
+	// DOWNLOAD ALL downloads a list of files.
+	if tok, _ := p.scanIgnoreWhitespace(); tok == ALL {
+		return p.parseDownloadAll(stmt)
+	}
+	p.unscan()
+
 	// Next we should read FROM.
 	if tok, lit := p.scanIgnoreWhitespace(); tok != FROM {
-		return nil, newParseError(Tokstr(tok, lit), []string{"FROM"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"FROM"})
 	}
 
 	// Next we should read a URL.
-	tok, lit := p.scanIgnoreWhitespace()
+	tok, lit := p.scanValue()
 	if tok != IDENT {
-		return nil, newParseError(Tokstr(tok, lit), []string{"URL"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"URL"})
 	}
 	stmt.URL = lit
 
+	// Mirrors follow the URL, each after an OR.
+	for {
+		if tok, _ := p.scanIgnoreWhitespace(); tok != OR {
+			p.unscan()
+			break
+		}
+		tok, lit := p.scanValue()
+		if tok != IDENT {
+			return nil, p.newParseError(Tokstr(tok, lit), []string{"URL"})
+		}
+		stmt.Mirrors = append(stmt.Mirrors, lit)
+	}
+	if tok, _ := p.scanIgnoreWhitespace(); tok == FASTEST {
+		if len(stmt.Mirrors) == 0 {
+			return nil, p.newParseError(Tokstr(tok, "FASTEST"), []string{"OR", "SAVE"})
+		}
+		stmt.FastestFirst = true
+	} else {
+		p.unscan()
+	}
+
 	// Next we should read SAVE.
 	if tok, lit := p.scanIgnoreWhitespace(); tok != SAVE {
-		return nil, newParseError(Tokstr(tok, lit), []string{"SAVE"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"SAVE"})
 	}
 
 	// Next we should read TO.
 	if tok, lit := p.scanIgnoreWhitespace(); tok != TO {
-		return nil, newParseError(Tokstr(tok, lit), []string{"To"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"To"})
 	}
 
 	// And finally, we should read a filepath.
-	tok, lit = p.scanIgnoreWhitespace()
+	tok, lit = p.scanValue()
 	if tok != IDENT {
-		return nil, newParseError(Tokstr(tok, lit), []string{"FILEPATH"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"FILEPATH"})
 	}
 	stmt.FilePath = lit
 
-	stmt.IsBackground = p.scanAmpersand()
+	// Then the headers and credentials of the request.
+	if err := p.parseDownloadRequestOptions(&stmt.Request); err != nil {
+		return nil, err
+	}
+
+	// Then any number of VERIFY clauses.
+	for {
+		if tok, _ := p.scanIgnoreWhitespace(); tok != VERIFY {
+			p.unscan()
+			break
+		}
+		v, err := p.parseVerification()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Verifications = append(stmt.Verifications, v)
+	}
+
+	// Then an optional EXTRACT TO "dir" [STRIP "n"] clause.
+	if tok, _ := p.scanIgnoreWhitespace(); tok == EXTRACT {
+		e, err := p.parseExtraction()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Extract = e
+	} else {
+		p.unscan()
+	}
+
+	if err := p.parseBaseStatement(&stmt.BaseStatement); err != nil {
+		return nil, err
+	}
 
 	// Return the successfully parsed statement.
 	return stmt, nil
 }
 
+// parseDownloadAll parses the rest of a DOWNLOAD ALL statement; FROM followed by either a parenthesized
+// list of URLs or MANIFEST and the path of a file listing them.
+func (p *Parser) parseDownloadAll(stmt *DownloadStatement) (*DownloadStatement, error) {
+	stmt.All = true
+
+	// Next we should read FROM.
+	if tok, lit := p.scanIgnoreWhitespace(); tok != FROM {
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"FROM"})
+	}
+
+	// Then either a manifest or a list of comma separated URLs.
+	switch tok, lit := p.scanIgnoreWhitespace(); tok {
+	case MANIFEST:
+		m, err := p.parseIdent("MANIFEST")
+		if err != nil {
+			return nil, err
+		}
+		stmt.Manifest = m
+	case LEFTPARENTHESIS:
+		for {
+			u, err := p.parseIdent("URL")
+			if err != nil {
+				return nil, err
+			}
+			stmt.URLs = append(stmt.URLs, u)
+			tok, lit := p.scanIgnoreWhitespace()
+			if tok == RIGHTPARENTHESIS {
+				break
+			}
+			if tok != COMMA {
+				return nil, p.newParseError(Tokstr(tok, lit), []string{",", ")"})
+			}
+		}
+	default:
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"(", "MANIFEST"})
+	}
+
+	// Next we should read SAVE TO and the directory.
+	if tok, lit := p.scanIgnoreWhitespace(); tok != SAVE {
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"SAVE"})
+	}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != TO {
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"To"})
+	}
+	dir, err := p.parseIdent("DIRECTORY")
+	if err != nil {
+		return nil, err
+	}
+	stmt.FilePath = dir
+
+	// Then the headers and credentials of the requests.
+	if err := p.parseDownloadRequestOptions(&stmt.Request); err != nil {
+		return nil, err
+	}
+
+	// Then an optional ON ERROR CONTINUE or ON ERROR ABORT.
+	if tok, _ := p.scanIgnoreWhitespace(); tok == ON {
+		if tok, lit := p.scanIgnoreWhitespace(); tok != ERROR {
+			return nil, p.newParseError(Tokstr(tok, lit), []string{"ERROR"})
+		}
+		switch tok, lit := p.scanIgnoreWhitespace(); tok {
+		case CONTINUE:
+			stmt.ContinueOnError = true
+		case ABORT:
+		default:
+			return nil, p.newParseError(Tokstr(tok, lit), []string{"CONTINUE", "ABORT"})
+		}
+	} else {
+		p.unscan()
+	}
+
+	if err := p.parseBaseStatement(&stmt.BaseStatement); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
 // parseSQLExecuteStatement parses a SQLExecuteStatement statement.
 func (p *Parser) parseSQLExecuteStatement() (*SQLExecuteStatement, error) {
 	stmt := &SQLExecuteStatement{}
@@ -171,33 +712,37 @@ func (p *Parser) parseSQLExecuteStatement() (*SQLExecuteStatement, error) {
 
 	// First token should be a "SQLEXECUTE" keyword.
 	if tok, lit := p.scanIgnoreWhitespace(); tok != SQLEXECUTE {
-		return nil, newParseError(Tokstr(tok, lit), []string{"SQLEXECUTE"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"SQLEXECUTE"})
 	}
 
 	// Next we should read FROM.
 	if tok, lit := p.scanIgnoreWhitespace(); tok != FROM {
-		return nil, newParseError(Tokstr(tok, lit), []string{"FROM"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"FROM"})
 	}
 
 	// Next we should read a URL.
-	tok, lit := p.scanIgnoreWhitespace()
+	tok, lit := p.scanValue()
 	if tok != IDENT {
-		return nil, newParseError(Tokstr(tok, lit), []string{"FILEPATH"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"FILEPATH"})
 	}
 	stmt.FilePath = lit
 
 	// Next we should read SAVE.
 	if tok, lit := p.scanIgnoreWhitespace(); tok != INTO {
-		return nil, newParseError(Tokstr(tok, lit), []string{"INTO"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"INTO"})
 	}
 
 	// And finally, we should read a filepath.
-	tok, lit = p.scanIgnoreWhitespace()
+	tok, lit = p.scanValue()
 	if tok != IDENT {
-		return nil, newParseError(Tokstr(tok, lit), []string{"DB"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"DB"})
 	}
 	stmt.DBConnectionString = lit
 
+	if err := p.parseBaseStatement(&stmt.BaseStatement); err != nil {
+		return nil, err
+	}
+
 	// Return the successfully parsed statement.
 	return stmt, nil
 }
@@ -209,41 +754,91 @@ func (p *Parser) parseRefresherStatement() (*RefreshStatement, error) {
 
 	// First token should be a "REFRESH" keyword.
 	if tok, lit := p.scanIgnoreWhitespace(); tok != REFRESH {
-		return nil, newParseError(Tokstr(tok, lit), []string{"REFRESH"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"REFRESH"})
 	}
 
 	// Next we should read TOKEN or CERTIFICATE.
 	artTok, artLit := p.scanIgnoreWhitespace()
 	if artTok != TOKEN && artTok != CERTIFICATE {
-		return nil, newParseError(Tokstr(artTok, artLit), []string{"TOKEN", "CERTIFICATE"})
+		return nil, p.newParseError(Tokstr(artTok, artLit), []string{"TOKEN", "CERTIFICATE"})
 	}
 	stmt.Artifact = Tokstr(artTok, artLit)
 
 	// Next we should read FROM.
 	if tok, lit := p.scanIgnoreWhitespace(); tok != FROM {
-		return nil, newParseError(Tokstr(tok, lit), []string{"FROM"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"FROM"})
 	}
 
 	// Next we should read a URL.
-	tok, lit := p.scanIgnoreWhitespace()
+	tok, lit := p.scanValue()
 	if tok != IDENT {
-		return nil, newParseError(Tokstr(tok, lit), []string{"PATH"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"PATH"})
 	}
 	stmt.Path = lit
 
 	// Next we should see the "EVERY" keyword.
 	if tok, lit := p.scanIgnoreWhitespace(); tok != EVERY {
-		return nil, newParseError(Tokstr(tok, lit), []string{"EVERY"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"EVERY"})
 	}
 
 	// Next we should read polling interval.
-	tok, lit = p.scanIgnoreWhitespace()
+	tok, lit = p.scanValue()
 	if tok != IDENT {
-		return nil, newParseError(Tokstr(tok, lit), []string{"PollingInterval"})
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"PollingInterval"})
 	}
 	stmt.Interval = lit
 
-	stmt.IsBackground = p.scanAmpersand()
+	// If the next token is SAVE then look for TO and a destination.
+	if tok, _ = p.scanIgnoreWhitespace(); tok == SAVE {
+		if tok, lit := p.scanIgnoreWhitespace(); tok != TO {
+			return nil, p.newParseError(Tokstr(tok, lit), []string{"TO"})
+		}
+		tok, lit = p.scanValue()
+		if tok != IDENT {
+			return nil, p.newParseError(Tokstr(tok, lit), []string{"DESTINATION"})
+		}
+		stmt.Destination = lit
+	} else {
+		p.unscan()
+	}
+
+	if err := p.parseBaseStatement(&stmt.BaseStatement); err != nil {
+		return nil, err
+	}
+
+	// Return the successfully parsed statement.
+	return stmt, nil
+}
+
+// parseSetStatement parses a SET statement.
+func (p *Parser) parseSetStatement() (*SetStatement, error) {
+	stmt := &SetStatement{}
+	p.unscan()
+
+	// First token should be a "SET" keyword.
+	if tok, lit := p.scanIgnoreWhitespace(); tok != SET {
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"SET"})
+	}
+
+	// Next we should read the variable name. Keywords are accepted so that clauses added to the
+	// language do not break the scripts using their words as variable names.
+	tok, lit := p.scanIgnoreWhitespace()
+	if (tok != IDENT && !tok.isKeyword()) || lit == "" {
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"NAME"})
+	}
+	stmt.Name = lit
+
+	// Next we should see "=".
+	if tok, lit := p.scanIgnoreWhitespace(); tok != EQ {
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"="})
+	}
+
+	// And finally, we should read the value.
+	tok, lit = p.scanValue()
+	if tok != IDENT {
+		return nil, p.newParseError(Tokstr(tok, lit), []string{"VALUE"})
+	}
+	stmt.Value = lit
 
 	// Return the successfully parsed statement.
 	return stmt, nil
@@ -262,35 +857,51 @@ func (p *Parser) ParseStatement() (Statement, error) {
 		return p.parseSQLExecuteStatement()
 	case REFRESH:
 		return p.parseRefresherStatement()
+	case SET:
+		return p.parseSetStatement()
 	case LEFTPARENTHESIS:
 		return p.ParseQuery()
-	default:
-		return nil, newParseError(Tokstr(tok, lit), []string{"POLL", "DOWNLOAD", "SQLEXECUTE", "QUERY"})
 	}
+	if parse := lookupRegisteredStatement(tok); parse != nil {
+		return parse(p)
+	}
+	expected := []string{"POLL", "DOWNLOAD", "SQLEXECUTE", "REFRESH", "SET"}
+	expected = append(expected, registeredStatementNames()...)
+	return nil, p.newParseError(Tokstr(tok, lit), append(expected, "QUERY"))
 }
 
 // ParseQuery parses an query string and returns a Query AST object.
+// The parser recovers from an invalid statement at the next ; so that
+// all syntax errors of a query are returned together as ParseErrors.
 func (p *Parser) ParseQuery() (*Query, error) {
 	var statements Statements
+	var errs ParseErrors
 	semi := true
 	for {
 		if tok, lit := p.scanIgnoreWhitespace(); tok == EOF || tok == RIGHTPARENTHESIS {
-			return &Query{
-				BaseStatement{
-					p.scanAmpersand(),
-				},
-				statements,
-			}, nil
+			q := &Query{Statements: statements}
+			// consume the clauses of a nested query so that they are not mistaken for the next statement
+			if err := p.parseBaseStatement(&q.BaseStatement); err != nil {
+				errs = errs.appendError(err)
+			}
+			if len(errs) > 0 {
+				return nil, errs
+			}
+			return q, nil
 		} else if tok == SEMICOLON {
 			semi = true
 		} else {
 			if !semi {
-				return nil, newParseError(Tokstr(tok, lit), []string{";"})
+				errs = errs.appendError(p.newParseError(Tokstr(tok, lit), []string{";"}))
+				p.skipStatement()
+				continue
 			}
 			p.unscan()
 			s, err := p.ParseStatement()
 			if err != nil {
-				return nil, err
+				errs = errs.appendError(err)
+				p.skipStatement()
+				continue
 			}
 			statements = append(statements, s)
 			semi = false
@@ -298,6 +909,27 @@ func (p *Parser) ParseQuery() (*Query, error) {
 	}
 }
 
+// skipStatement consumes tokens up to the next ; so parsing can resume with the next statement.
+// A closing parenthesis or EOF is left on the buffer for the enclosing query.
+func (p *Parser) skipStatement() {
+	// The token that caused the error may already be a statement boundary.
+	if p.buf.n == 0 {
+		switch p.buf.tok {
+		case SEMICOLON, EOF, RIGHTPARENTHESIS:
+			p.unscan()
+			return
+		}
+	}
+	for {
+		tok, _ := p.scan()
+		switch tok {
+		case SEMICOLON, EOF, RIGHTPARENTHESIS:
+			p.unscan()
+			return
+		}
+	}
+}
+
 // ParseError represents an error that occurred during parsing.
 type ParseError struct {
 	Message  string
@@ -306,17 +938,39 @@ type ParseError struct {
 	Pos      Pos
 }
 
-// newParseError returns a new instance of ParseError.
-func newParseError(found string, expected []string) *ParseError {
-	return &ParseError{Found: found, Expected: expected}
+// newParseError returns a new instance of ParseError at the position of the last read token.
+func (p *Parser) newParseError(found string, expected []string) *ParseError {
+	return &ParseError{Found: found, Expected: expected, Pos: p.buf.pos}
 }
 
 // Error returns the string representation of the error.
 func (e *ParseError) Error() string {
 	if e.Message != "" {
-		//return fmt.Sprintf("%s at line %d, char %d", e.Message, e.Pos.Line+1, e.Pos.Char+1)
-		return fmt.Sprintf("%s", e.Message)
+		return fmt.Sprintf("%s at line %d, char %d", e.Message, e.Pos.Line+1, e.Pos.Char+1)
+	}
+	return fmt.Sprintf("found %s, expected %s at line %d, char %d", e.Found, strings.Join(e.Expected, ", "), e.Pos.Line+1, e.Pos.Char+1)
+}
+
+// ParseErrors is a list of errors found while parsing a query.
+type ParseErrors []*ParseError
+
+// Error returns the string representation of all errors, one per line.
+func (e ParseErrors) Error() string {
+	var str []string
+	for _, err := range e {
+		str = append(str, err.Error())
+	}
+	return strings.Join(str, "\n")
+}
+
+// appendError adds err to the list. ParseErrors from nested queries are flattened.
+func (e ParseErrors) appendError(err error) ParseErrors {
+	switch v := err.(type) {
+	case ParseErrors:
+		return append(e, v...)
+	case *ParseError:
+		return append(e, v)
+	default:
+		return append(e, &ParseError{Message: err.Error()})
 	}
-	//return fmt.Sprintf("found %s, expected %s at line %d, char %d", e.Found, strings.Join(e.Expected, ", "), e.Pos.Line+1, e.Pos.Char+1)
-	return fmt.Sprintf("found %s, expected %s", e.Found, strings.Join(e.Expected, ", "))
 }
@@ -1,6 +1,7 @@
 package lexer_test
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 
@@ -39,6 +40,14 @@ func TestParsePositive(t *testing.T) {
 				"http://foo.bar",
 				"10m",
 				"10",
+				nil,
+				lexer.RequestOptions{},
+				nil,
+				"",
+				nil,
+				"",
+				false,
+				"",
 			},
 		},
 		{
@@ -56,6 +65,15 @@ func TestParsePositive(t *testing.T) {
 				},
 				"http://foo.bar",
 				"/path/to/file",
+				lexer.RequestOptions{},
+				nil,
+				nil,
+				nil,
+				false,
+				false,
+				nil,
+				"",
+				false,
 			},
 		},
 		{
@@ -65,6 +83,16 @@ func TestParsePositive(t *testing.T) {
 				DBConnectionString: "jdbc://foo.bar?ssl=true",
 			},
 		},
+		{
+			"sqlexecute from \"/path/to/file\" into \"jdbc://foo.bar?ssl=true\" &",
+			&lexer.SQLExecuteStatement{
+				lexer.BaseStatement{
+					IsBackground: true,
+				},
+				"jdbc://foo.bar?ssl=true",
+				"/path/to/file",
+			},
+		},
 		{
 			"refresh token from \"/path/to/file\" every \"24h\"",
 			&lexer.RefreshStatement{
@@ -82,6 +110,37 @@ func TestParsePositive(t *testing.T) {
 				"token",
 				"/path/to/file",
 				"24h",
+				"",
+			},
+		},
+		{
+			"refresh certificate from \"pki/issue/web?common_name=foo.bar\" every \"24h\" save to \"/etc/certs\" &",
+			&lexer.RefreshStatement{
+				BaseStatement: lexer.BaseStatement{
+					IsBackground: true,
+				},
+				Artifact:    "certificate",
+				Path:        "pki/issue/web?common_name=foo.bar",
+				Interval:    "24h",
+				Destination: "/etc/certs",
+			},
+		},
+		{
+			"download from \"http://foo.bar\" save to \"/path/to/file\" timeout \"5m\" &",
+			&lexer.DownloadStatement{
+				BaseStatement: lexer.BaseStatement{
+					IsBackground: true,
+					Timeout:      "5m",
+				},
+				URL:      "http://foo.bar",
+				FilePath: "/path/to/file",
+			},
+		},
+		{
+			"set host = \"http://foo.bar\"",
+			&lexer.SetStatement{
+				Name:  "host",
+				Value: "http://foo.bar",
 			},
 		},
 		{
@@ -135,11 +194,14 @@ func TestParseNegative(t *testing.T) {
 		query string
 		err   string
 	}{
-		{"poll every \"2 seconds\" after \"10 minutes\" \"10\" times", "found every, expected URL"},
-		{"poll \"URL\" every \"2 seconds\" after \"10 minutes\" ", "found EOF, expected MaxRetryCount"},
-		{"download from save ", "found save, expected URL"},
-		{"download \"URL\" save ", "found URL, expected FROM"},
-		{"(sqlexecute from \"/path/to/file\" into \"jdbc://foo.bar?ssl=true\" sqlexecute from \"/path/to/file\" into \"jdbc://foo.bar?ssl=true\")", "found sqlexecute, expected ;"},
+		{"poll every \"2 seconds\" after \"10 minutes\" \"10\" times", "found every, expected URL at line 1, char 6"},
+		{"poll \"URL\" every \"2 seconds\" after \"10 minutes\" ", "found EOF, expected MaxRetryCount at line 1, char 49"},
+		{"download from save ", "found save, expected URL at line 1, char 15"},
+		{"download \"URL\" save ", "found URL, expected FROM at line 1, char 10"},
+		{"download from \"URL\" save to \"/path/to/file\" timeout &", "found &, expected Timeout at line 1, char 53"},
+		{"set host \"http://foo.bar\"", "found http://foo.bar, expected = at line 1, char 10"},
+		{"refresh certificate from \"pki/issue/web\" every \"24h\" save \"/etc/certs\"", "found /etc/certs, expected TO at line 1, char 59"},
+		{"(sqlexecute from \"/path/to/file\" into \"jdbc://foo.bar?ssl=true\" sqlexecute from \"/path/to/file\" into \"jdbc://foo.bar?ssl=true\")", "found sqlexecute, expected ; at line 1, char 65"},
 	}
 	for _, c := range tests {
 		parser := lexer.NewParser(strings.NewReader(c.query))
@@ -153,6 +215,27 @@ func TestParseNegative(t *testing.T) {
 	}
 }
 
+func TestParseQueryMultipleErrors(t *testing.T) {
+	s := "poll ;\ndownload from \"a\" save to \"b\";\n(poll \"x\" every;  download \"y\") &;\n download from \"a\" save \"b\""
+	_, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+	if err == nil {
+		t.Fatalf("expected error .got nil")
+	}
+	errs, ok := err.(lexer.ParseErrors)
+	if !ok {
+		t.Fatalf("expected ParseErrors. got %T", err)
+	}
+	expected := []lexer.Pos{{Line: 0, Char: 5}, {Line: 2, Char: 15}, {Line: 2, Char: 27}, {Line: 3, Char: 24}}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors. got %d: %v", len(expected), len(errs), err)
+	}
+	for i, e := range errs {
+		if e.Pos != expected[i] {
+			t.Errorf("expected %v. got %v", expected[i], e.Pos)
+		}
+	}
+}
+
 func TestParseQuery(t *testing.T) {
 	s := `download from "http://foo.bar" save to "/path/to/file"; download from "http://bar.foo" save to "/path/to/another/file"`
 	q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
@@ -187,6 +270,23 @@ func TestNestedParseQuery(t *testing.T) {
 	}
 }
 
+func TestNestedQueryTimeout(t *testing.T) {
+	s := `(download from "http://foo.bar" save to "/path/to/file") timeout "1m" &; download from "http://foo.bar" save to "/path/to/file"`
+	q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	} else if len(q.Statements) != 2 {
+		t.Fatalf("expected 2 statements. got %d", len(q.Statements))
+	}
+	nested, ok := q.Statements[0].(*lexer.Query)
+	if !ok {
+		t.Fatalf("expected QUERY as first statement . got %v", q.Statements[0])
+	}
+	if nested.Timeout != "1m" || !nested.IsBackground {
+		t.Fatalf("expected timeout 1m in background. got %q %v", nested.Timeout, nested.IsBackground)
+	}
+}
+
 func TestTrailingSemicolon(t *testing.T) {
 	s := `download from "http://foo.bar" save to "/path/to/file";`
 	q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
@@ -206,3 +306,515 @@ func TestParserEmpty(t *testing.T) {
 		t.Fatalf("expected 0 statements. got %d", len(q.Statements))
 	}
 }
+
+func TestStringRoundTrip(t *testing.T) {
+	s := `set file = "/tmp/a \"quoted\" name"; poll "http://foo.bar" every "2s" after "10m" "10" times timeout "1m";
+	(download from "http://foo.bar" save to "${file}" &; sqlexecute from "/path/to/file" into "postgres://foo.bar/db" &) timeout "5m" &;
+	refresh certificate from "pki/issue/nestor?common_name=foo.bar" every "1h" save to "/etc/certs"`
+	q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	printed, err := lexer.NewParser(strings.NewReader(q.String())).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil for %s. got %v", q.String(), err)
+	}
+	if !reflect.DeepEqual(q, printed) {
+		t.Fatalf("expected %s. got %s", q.String(), printed.String())
+	}
+}
+
+func TestScheduleClauses(t *testing.T) {
+	stmt, err := lexer.NewParser(strings.NewReader(`download from "http://foo.bar" save to "/tmp/foo" timeout "1m" schedule "0 */6 * * *" at startup &`)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	dl := stmt.(*lexer.DownloadStatement)
+	if dl.Schedule != "0 */6 * * *" || !dl.AtStartup || dl.Timeout != "1m" || !dl.IsBackground {
+		t.Fatalf("expected schedule, startup, timeout and background. got %+v", dl.BaseStatement)
+	}
+	expected := `DOWNLOAD FROM "http://foo.bar" SAVE TO "/tmp/foo" AT STARTUP SCHEDULE "0 */6 * * *" TIMEOUT "1m" &`
+	if stmt.String() != expected {
+		t.Fatalf("expected %s. got %s", expected, stmt.String())
+	}
+	q, err := lexer.NewParser(strings.NewReader(`(poll "http://foo.bar" every "1s" "1" times) schedule "@every 1h"`)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if nested := q.Statements[0].(*lexer.Query); nested.Schedule != "@every 1h" {
+		t.Fatalf("expected @every 1h. got %s", nested.Schedule)
+	}
+}
+
+func TestScheduleMissedRun(t *testing.T) {
+	for _, c := range []struct {
+		s         string
+		missedRun lexer.Token
+		expected  string
+	}{
+		{`poll "http://foo.bar" every "1s" "1" times schedule "@every 1m"`, lexer.ILLEGAL,
+			`POLL "http://foo.bar" EVERY "1s" "1" TIMES SCHEDULE "@every 1m"`},
+		{`poll "http://foo.bar" every "1s" "1" times schedule "@every 1m" on missed once timeout "1m"`, lexer.ONCE,
+			`POLL "http://foo.bar" EVERY "1s" "1" TIMES SCHEDULE "@every 1m" ON MISSED ONCE TIMEOUT "1m"`},
+		{`download all from manifest "/etc/files.txt" save to "/tmp/foo" on error continue schedule "@hourly" on missed skip &`, lexer.SKIP,
+			`DOWNLOAD ALL FROM MANIFEST "/etc/files.txt" SAVE TO "/tmp/foo" ON ERROR CONTINUE SCHEDULE "@hourly" ON MISSED SKIP &`},
+	} {
+		stmt, err := lexer.NewParser(strings.NewReader(c.s)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil for %s. got %v", c.s, err)
+		}
+		if b := stmt.(interface{ Base() *lexer.BaseStatement }).Base(); b.MissedRun != c.missedRun {
+			t.Fatalf("expected %v for %s. got %v", c.missedRun, c.s, b.MissedRun)
+		}
+		if stmt.String() != c.expected {
+			t.Fatalf("expected %s. got %s", c.expected, stmt.String())
+		}
+	}
+}
+
+func TestScheduleClausesNegative(t *testing.T) {
+	for _, s := range []string{
+		`download from "http://foo.bar" save to "/tmp/foo" schedule "@hourly" schedule "@daily"`,
+		`download from "http://foo.bar" save to "/tmp/foo" at boot`,
+		`download from "http://foo.bar" save to "/tmp/foo" schedule`,
+		`download from "http://foo.bar" save to "/tmp/foo" schedule "@hourly" on missed`,
+		`download from "http://foo.bar" save to "/tmp/foo" schedule "@hourly" on missed all`,
+		`download from "http://foo.bar" save to "/tmp/foo" schedule "@hourly" on error continue`,
+	} {
+		if _, err := lexer.NewParser(strings.NewReader(s)).ParseStatement(); err == nil {
+			t.Fatalf("expected error for %s. got nil", s)
+		}
+	}
+}
+
+func TestPollExpectations(t *testing.T) {
+	stmt, err := lexer.NewParser(strings.NewReader(`poll "http://foo.bar/health" every "1s" "5" times expect status 204 expect body =~ "ok|up" expect json "$.checks[0].status" != "DOWN" &`)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	poll := stmt.(*lexer.PollStatement)
+	expected := []*lexer.Expectation{
+		{Subject: lexer.STATUS, Operator: lexer.EQ, Value: "204"},
+		{Subject: lexer.BODY, Operator: lexer.EQREGEX, Value: "ok|up"},
+		{Subject: lexer.JSON, Path: "$.checks[0].status", Operator: lexer.NEQ, Value: "DOWN"},
+	}
+	if !reflect.DeepEqual(poll.Expectations, expected) {
+		t.Fatalf("expected %v. got %v", expected, poll.Expectations)
+	}
+	if !poll.IsBackground {
+		t.Fatalf("expected background. got foreground")
+	}
+	printed := `POLL "http://foo.bar/health" EVERY "1s" "5" TIMES EXPECT STATUS = "204" EXPECT BODY =~ "ok|up" EXPECT JSON "$.checks[0].status" != "DOWN" &`
+	if stmt.String() != printed {
+		t.Fatalf("expected %s. got %s", printed, stmt.String())
+	}
+}
+
+func TestPollExpectationsNegative(t *testing.T) {
+	for _, s := range []string{
+		`poll "http://foo.bar" every "1s" "5" times expect header = "foo"`,
+		`poll "http://foo.bar" every "1s" "5" times expect body "ok"`,
+		`poll "http://foo.bar" every "1s" "5" times expect json = "UP"`,
+		`poll "http://foo.bar" every "1s" "5" times expect status =`,
+	} {
+		if _, err := lexer.NewParser(strings.NewReader(s)).ParseStatement(); err == nil {
+			t.Fatalf("expected error for %s. got nil", s)
+		}
+	}
+}
+
+func TestPollRequestOptions(t *testing.T) {
+	stmt, err := lexer.NewParser(strings.NewReader(`poll "http://foo.bar/ready" body "{}" header "Accept" "application/json" method "POST" auth basic "admin" "${password}" header "X-Probe" "nestor" every "1s" "5" times`)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	expected := lexer.RequestOptions{
+		Method: "POST",
+		Headers: []*lexer.Header{
+			{Name: "Accept", Value: "application/json"},
+			{Name: "X-Probe", Value: "nestor"},
+		},
+		Auth: &lexer.Auth{Scheme: lexer.BASIC, Username: "admin", Password: "${password}"},
+		Body: "{}",
+	}
+	poll := stmt.(*lexer.PollStatement)
+	if !reflect.DeepEqual(poll.Request, expected) {
+		t.Fatalf("expected %v. got %v", expected, poll.Request)
+	}
+	printed := `POLL "http://foo.bar/ready" METHOD "POST" HEADER "Accept" "application/json" HEADER "X-Probe" "nestor" AUTH BASIC "admin" "${password}" BODY "{}" EVERY "1s" "5" TIMES`
+	if stmt.String() != printed {
+		t.Fatalf("expected %s. got %s", printed, stmt.String())
+	}
+}
+
+func TestPollRequestOptionsNegative(t *testing.T) {
+	for _, s := range []string{
+		`poll "http://foo.bar" method "POST" method "PUT" every "1s" "5" times`,
+		`poll "http://foo.bar" auth bearer "a" auth bearer "b" every "1s" "5" times`,
+		`poll "http://foo.bar" auth digest "a" every "1s" "5" times`,
+		`poll "http://foo.bar" auth basic "admin" every "1s" "5" times`,
+		`poll "http://foo.bar" header "X-Probe" every "1s" "5" times`,
+	} {
+		if _, err := lexer.NewParser(strings.NewReader(s)).ParseStatement(); err == nil {
+			t.Fatalf("expected error for %s. got nil", s)
+		}
+	}
+}
+
+func TestPollBackoff(t *testing.T) {
+	stmt, err := lexer.NewParser(strings.NewReader(`poll "http://foo.bar" every "1s" "50" times expect status 204 deadline "10m" backoff exponential max "30s"`)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	poll := stmt.(*lexer.PollStatement)
+	if !reflect.DeepEqual(poll.Backoff, &lexer.Backoff{Policy: lexer.EXPONENTIAL, Max: "30s"}) || poll.Deadline != "10m" {
+		t.Fatalf("expected exponential backoff and deadline. got %v %s", poll.Backoff, poll.Deadline)
+	}
+	printed := `POLL "http://foo.bar" EVERY "1s" "50" TIMES BACKOFF EXPONENTIAL MAX "30s" DEADLINE "10m" EXPECT STATUS = "204"`
+	if stmt.String() != printed {
+		t.Fatalf("expected %s. got %s", printed, stmt.String())
+	}
+	for _, s := range []string{
+		`poll "http://foo.bar" every "1s" "50" times backoff linear`,
+		`poll "http://foo.bar" every "1s" "50" times backoff fixed max "30s"`,
+		`poll "http://foo.bar" every "1s" "50" times backoff fixed backoff jitter`,
+		`poll "http://foo.bar" every "1s" "50" times deadline`,
+	} {
+		if _, err := lexer.NewParser(strings.NewReader(s)).ParseQuery(); err == nil {
+			t.Fatalf("expected error for %s. got nil", s)
+		}
+	}
+}
+
+func TestPollQuorum(t *testing.T) {
+	for _, c := range []struct {
+		query   string
+		quorum  string
+		printed string
+	}{
+		{`poll "http://a", "http://b" every "1s" "5" times`, "", `POLL "http://a", "http://b" EVERY "1s" "5" TIMES`},
+		{`poll "http://a", "http://b", "tcp://c:1" quorum any every "1s" "5" times`, "ANY", `POLL "http://a", "http://b", "tcp://c:1" QUORUM ANY EVERY "1s" "5" TIMES`},
+		{`poll "http://a", "http://b" quorum "1" method "HEAD" every "1s" "5" times`, "1", `POLL "http://a", "http://b" QUORUM "1" METHOD "HEAD" EVERY "1s" "5" TIMES`},
+	} {
+		stmt, err := lexer.NewParser(strings.NewReader(c.query)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		poll := stmt.(*lexer.PollStatement)
+		if poll.Quorum != c.quorum || len(poll.Endpoints()) < 2 || poll.Endpoints()[1] != "http://b" {
+			t.Fatalf("expected quorum %s and endpoints. got %s %v", c.quorum, poll.Quorum, poll.Endpoints())
+		}
+		if stmt.String() != c.printed {
+			t.Fatalf("expected %s. got %s", c.printed, stmt.String())
+		}
+	}
+	for _, s := range []string{
+		`poll "http://a", every "1s" "5" times`,
+		`poll "http://a", "http://b" quorum every "1s" "5" times`,
+	} {
+		if _, err := lexer.NewParser(strings.NewReader(s)).ParseStatement(); err == nil {
+			t.Fatalf("expected error for %s. got nil", s)
+		}
+	}
+}
+
+func TestPollUntilDown(t *testing.T) {
+	for _, c := range []struct {
+		query     string
+		downCount string
+		printed   string
+	}{
+		{`poll "http://a" every "1s" "5" times until down`, "", `POLL "http://a" EVERY "1s" "5" TIMES UNTIL DOWN`},
+		{`poll "http://a" every "1s" "5" times until down "3" times &`, "3", `POLL "http://a" EVERY "1s" "5" TIMES UNTIL DOWN "3" TIMES &`},
+	} {
+		stmt, err := lexer.NewParser(strings.NewReader(c.query)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		poll := stmt.(*lexer.PollStatement)
+		if !poll.UntilDown || poll.DownCount != c.downCount {
+			t.Fatalf("expected until down %s. got %v %s", c.downCount, poll.UntilDown, poll.DownCount)
+		}
+		if stmt.String() != c.printed {
+			t.Fatalf("expected %s. got %s", c.printed, stmt.String())
+		}
+	}
+	for _, s := range []string{
+		`poll "http://a" every "1s" "5" times until up`,
+		`poll "http://a" every "1s" "5" times until down "3"`,
+		`poll "http://a" every "1s" "5" times until down until down`,
+	} {
+		if _, err := lexer.NewParser(strings.NewReader(s)).ParseStatement(); err == nil {
+			t.Fatalf("expected error for %s. got nil", s)
+		}
+	}
+}
+
+func TestDownloadVerify(t *testing.T) {
+	s := `download from "http://a/f" save to "/tmp/f" verify sha256 "abcd" verify signature from "http://a/f.sig" &`
+	stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	expected := []*lexer.Verification{
+		{Algorithm: lexer.SHA256, Checksum: "abcd"},
+		{Algorithm: lexer.SIGNATURE, From: "http://a/f.sig"},
+	}
+	if !reflect.DeepEqual(stmt.(*lexer.DownloadStatement).Verifications, expected) {
+		t.Fatalf("expected %v. got %v", expected, stmt.(*lexer.DownloadStatement).Verifications)
+	}
+	printed := `DOWNLOAD FROM "http://a/f" SAVE TO "/tmp/f" VERIFY SHA256 "abcd" VERIFY SIGNATURE FROM "http://a/f.sig" &`
+	if stmt.String() != printed {
+		t.Fatalf("expected %s. got %s", printed, stmt.String())
+	}
+	stmt, err = lexer.NewParser(strings.NewReader(`download from "http://a/f" save to "/tmp/f" verify sha512 from "http://a/f.sha512"`)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if v := stmt.(*lexer.DownloadStatement).Verifications; len(v) != 1 || v[0].Algorithm != lexer.SHA512 || v[0].From != "http://a/f.sha512" {
+		t.Fatalf("expected sha512 verification from sidecar. got %v", v)
+	}
+	for _, s := range []string{
+		`download from "http://a/f" save to "/tmp/f" verify`,
+		`download from "http://a/f" save to "/tmp/f" verify md5 "abcd"`,
+		`download from "http://a/f" save to "/tmp/f" verify sha256`,
+		`download from "http://a/f" save to "/tmp/f" verify signature "abcd"`,
+		`download from "http://a/f" save to "/tmp/f" verify sha256 from`,
+	} {
+		if _, err := lexer.NewParser(strings.NewReader(s)).ParseStatement(); err == nil {
+			t.Fatalf("expected error for %s. got nil", s)
+		}
+	}
+}
+
+func TestDownloadRequestOptions(t *testing.T) {
+	s := `download from "http://a/f" save to "/tmp/f" with header "X-Repo" "libs" auth bearer "${vault:secret/nexus#token}" header "Accept" "*/*" verify sha256 "abcd"`
+	stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	expected := lexer.RequestOptions{
+		Headers: []*lexer.Header{{Name: "X-Repo", Value: "libs"}, {Name: "Accept", Value: "*/*"}},
+		Auth:    &lexer.Auth{Scheme: lexer.BEARER, Token: "${vault:secret/nexus#token}"},
+	}
+	if !reflect.DeepEqual(stmt.(*lexer.DownloadStatement).Request, expected) {
+		t.Fatalf("expected %v. got %v", expected, stmt.(*lexer.DownloadStatement).Request)
+	}
+	printed := `DOWNLOAD FROM "http://a/f" SAVE TO "/tmp/f" WITH HEADER "X-Repo" "libs" HEADER "Accept" "*/*" AUTH BEARER "${vault:secret/nexus#token}" VERIFY SHA256 "abcd"`
+	if stmt.String() != printed {
+		t.Fatalf("expected %s. got %s", printed, stmt.String())
+	}
+	reparsed, err := lexer.NewParser(strings.NewReader(printed)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if !reflect.DeepEqual(reparsed, stmt) {
+		t.Fatalf("expected %v. got %v", stmt, reparsed)
+	}
+	stmt, err = lexer.NewParser(strings.NewReader(`download from "http://a/f" save to "/tmp/f" auth basic "user" "pass" &`)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if auth := stmt.(*lexer.DownloadStatement).Request.Auth; auth == nil || auth.Username != "user" || auth.Password != "pass" {
+		t.Fatalf("expected basic auth. got %v", auth)
+	}
+	for _, s := range []string{
+		`download from "http://a/f" save to "/tmp/f" with`,
+		`download from "http://a/f" save to "/tmp/f" with "X" "y"`,
+		`download from "http://a/f" save to "/tmp/f" with header "X"`,
+		`download from "http://a/f" save to "/tmp/f" auth digest "t"`,
+		`download from "http://a/f" save to "/tmp/f" auth bearer "t" auth bearer "u"`,
+		`download from "http://a/f" save to "/tmp/f" method "POST"`,
+	} {
+		if _, err := lexer.NewParser(strings.NewReader(s)).ParseQuery(); err == nil {
+			t.Fatalf("expected error for %s. got nil", s)
+		}
+	}
+}
+
+func TestDownloadExtract(t *testing.T) {
+	for _, c := range []struct {
+		query      string
+		extraction *lexer.Extraction
+		printed    string
+	}{
+		{
+			`download from "http://a/f.tgz" save to "/tmp/f.tgz" extract to "/opt/app"`,
+			&lexer.Extraction{Directory: "/opt/app"},
+			`DOWNLOAD FROM "http://a/f.tgz" SAVE TO "/tmp/f.tgz" EXTRACT TO "/opt/app"`,
+		},
+		{
+			`download from "http://a/f.tgz" save to "/tmp/f.tgz" verify sha256 "abcd" extract to "/opt/app" strip "1" &`,
+			&lexer.Extraction{Directory: "/opt/app", StripComponents: "1"},
+			`DOWNLOAD FROM "http://a/f.tgz" SAVE TO "/tmp/f.tgz" VERIFY SHA256 "abcd" EXTRACT TO "/opt/app" STRIP "1" &`,
+		},
+	} {
+		stmt, err := lexer.NewParser(strings.NewReader(c.query)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		if !reflect.DeepEqual(stmt.(*lexer.DownloadStatement).Extract, c.extraction) {
+			t.Fatalf("expected %v. got %v", c.extraction, stmt.(*lexer.DownloadStatement).Extract)
+		}
+		if stmt.String() != c.printed {
+			t.Fatalf("expected %s. got %s", c.printed, stmt.String())
+		}
+	}
+	for _, s := range []string{
+		`download from "http://a/f.tgz" save to "/tmp/f.tgz" extract "/opt/app"`,
+		`download from "http://a/f.tgz" save to "/tmp/f.tgz" extract to`,
+		`download from "http://a/f.tgz" save to "/tmp/f.tgz" extract to "/opt/app" strip`,
+		`download from "http://a/f.tgz" save to "/tmp/f.tgz" extract to "/opt/app" extract to "/opt/b"`,
+	} {
+		if _, err := lexer.NewParser(strings.NewReader(s)).ParseQuery(); err == nil {
+			t.Fatalf("expected error for %s. got nil", s)
+		}
+	}
+}
+
+func TestDownloadMirrors(t *testing.T) {
+	for _, c := range []struct {
+		query   string
+		mirrors []string
+		fastest bool
+		printed string
+	}{
+		{
+			`download from "http://a/f" or "http://b/f" save to "/tmp/f"`,
+			[]string{"http://b/f"},
+			false,
+			`DOWNLOAD FROM "http://a/f" OR "http://b/f" SAVE TO "/tmp/f"`,
+		},
+		{
+			`download from "http://a/f" or "http://b/f" or "http://c/f" fastest save to "/tmp/f" verify sha256 "abcd" &`,
+			[]string{"http://b/f", "http://c/f"},
+			true,
+			`DOWNLOAD FROM "http://a/f" OR "http://b/f" OR "http://c/f" FASTEST SAVE TO "/tmp/f" VERIFY SHA256 "abcd" &`,
+		},
+	} {
+		stmt, err := lexer.NewParser(strings.NewReader(c.query)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		d := stmt.(*lexer.DownloadStatement)
+		if !reflect.DeepEqual(d.Mirrors, c.mirrors) || d.FastestFirst != c.fastest {
+			t.Fatalf("expected %v %v. got %v %v", c.mirrors, c.fastest, d.Mirrors, d.FastestFirst)
+		}
+		if !reflect.DeepEqual(d.Sources(), append([]string{"http://a/f"}, c.mirrors...)) {
+			t.Fatalf("expected http://a/f first. got %v", d.Sources())
+		}
+		if stmt.String() != c.printed {
+			t.Fatalf("expected %s. got %s", c.printed, stmt.String())
+		}
+	}
+	for _, s := range []string{
+		`download from "http://a/f" or save to "/tmp/f"`,
+		`download from "http://a/f" fastest save to "/tmp/f"`,
+		`download from "http://a/f" or "http://b/f" fastest fastest save to "/tmp/f"`,
+	} {
+		if _, err := lexer.NewParser(strings.NewReader(s)).ParseQuery(); err == nil {
+			t.Fatalf("expected error for %s. got nil", s)
+		}
+	}
+}
+
+func TestDownloadAll(t *testing.T) {
+	for _, c := range []struct {
+		query   string
+		stmt    *lexer.DownloadStatement
+		printed string
+	}{
+		{
+			`download all from ("http://a/f", "http://b/g") save to "/tmp/files"`,
+			&lexer.DownloadStatement{All: true, URLs: []string{"http://a/f", "http://b/g"}, FilePath: "/tmp/files"},
+			`DOWNLOAD ALL FROM ("http://a/f", "http://b/g") SAVE TO "/tmp/files"`,
+		},
+		{
+			`download all from manifest "/etc/files.txt" save to "/tmp/files" with header "X-Repo" "libs" on error continue &`,
+			&lexer.DownloadStatement{
+				BaseStatement:   lexer.BaseStatement{IsBackground: true},
+				All:             true,
+				Manifest:        "/etc/files.txt",
+				FilePath:        "/tmp/files",
+				Request:         lexer.RequestOptions{Headers: []*lexer.Header{{Name: "X-Repo", Value: "libs"}}},
+				ContinueOnError: true,
+			},
+			`DOWNLOAD ALL FROM MANIFEST "/etc/files.txt" SAVE TO "/tmp/files" WITH HEADER "X-Repo" "libs" ON ERROR CONTINUE &`,
+		},
+		{
+			`download all from ("http://a/f") save to "/tmp/files" on error abort`,
+			&lexer.DownloadStatement{All: true, URLs: []string{"http://a/f"}, FilePath: "/tmp/files"},
+			`DOWNLOAD ALL FROM ("http://a/f") SAVE TO "/tmp/files"`,
+		},
+	} {
+		stmt, err := lexer.NewParser(strings.NewReader(c.query)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		if !reflect.DeepEqual(stmt, c.stmt) {
+			t.Fatalf("expected %v. got %v", c.stmt, stmt)
+		}
+		if stmt.String() != c.printed {
+			t.Fatalf("expected %s. got %s", c.printed, stmt.String())
+		}
+	}
+	for _, s := range []string{
+		`download all from "http://a/f" save to "/tmp/files"`,
+		`download all from () save to "/tmp/files"`,
+		`download all from ("http://a/f" "http://b/g") save to "/tmp/files"`,
+		`download all from ("http://a/f", "http://b/g" save to "/tmp/files"`,
+		`download all from manifest save to "/tmp/files"`,
+		`download all from ("http://a/f") save to "/tmp/files" on error ignore`,
+		`download all from ("http://a/f") save to "/tmp/files" verify sha256 "abcd"`,
+	} {
+		if _, err := lexer.NewParser(strings.NewReader(s)).ParseQuery(); err == nil {
+			t.Fatalf("expected error for %s. got nil", s)
+		}
+	}
+}
+
+func TestSetKeywordNames(t *testing.T) {
+	for _, name := range []string{"status", "body", "json", "all", "on", "error", "max", "or"} {
+		s := `set ` + name + ` = "x"`
+		stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil for %s. got %v", s, err)
+		}
+		if set := stmt.(*lexer.SetStatement); set.Name != name || set.Value != "x" {
+			t.Fatalf("expected %s = x. got %s = %s", name, set.Name, set.Value)
+		}
+		if _, err := lexer.NewParser(strings.NewReader(stmt.String())).ParseStatement(); err != nil {
+			t.Fatalf("expected %s to parse. got %v", stmt.String(), err)
+		}
+	}
+	for _, s := range []string{`set = "x"`, `set "" = "x"`, `set & = "x"`} {
+		if _, err := lexer.NewParser(strings.NewReader(s)).ParseStatement(); err == nil {
+			t.Fatalf("expected error for %s. got nil", s)
+		}
+	}
+}
+
+func TestKeywordValues(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`download from "http://h/x" save to body`, `DOWNLOAD FROM "http://h/x" SAVE TO "body"`},
+		{`download from "http://h/x" or all save to output`, `DOWNLOAD FROM "http://h/x" OR "all" SAVE TO "output"`},
+		{`download all from manifest status save to json`, `DOWNLOAD ALL FROM MANIFEST "status" SAVE TO "json"`},
+		{`sqlexecute from json into header`, `SQLEXECUTE FROM "json" INTO "header"`},
+		{`refresh token from status every "1h" save to down`, `REFRESH token FROM "status" EVERY "1h" SAVE TO "down"`},
+		{`poll "http://a" every "1s" "5" times expect body = down`, `POLL "http://a" EVERY "1s" "5" TIMES EXPECT BODY = "down"`},
+		{`download from "http://h/x" save to "f" timeout max`, `DOWNLOAD FROM "http://h/x" SAVE TO "f" TIMEOUT "max"`},
+		{`set dir = all`, `SET dir = "all"`},
+	}
+	for _, tt := range tests {
+		stmt, err := lexer.NewParser(strings.NewReader(tt.query)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil for %s. got %v", tt.query, err)
+		}
+		if stmt.String() != tt.expected {
+			t.Fatalf("expected %s. got %s", tt.expected, stmt.String())
+		}
+	}
+}
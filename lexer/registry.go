@@ -0,0 +1,119 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ParseFunc parses a statement registered with RegisterStatement.
+// The statement's keyword has already been consumed when the function is called.
+type ParseFunc func(p *Parser) (Statement, error)
+
+// ExtensionStatement is embedded by statements registered with RegisterStatement.
+// It provides the clauses shared by all statements and marks the type as a Statement.
+type ExtensionStatement struct {
+	BaseStatement
+}
+
+func (*ExtensionStatement) stmt() {}
+
+// registry holds the keywords and statements added at runtime.
+var registry = struct {
+	sync.RWMutex
+	next       Token
+	keywords   map[string]Token
+	names      map[Token]string
+	statements map[Token]ParseFunc
+	order      []string
+}{
+	next:       Token(len(tokens)),
+	keywords:   make(map[string]Token),
+	names:      make(map[Token]string),
+	statements: make(map[Token]ParseFunc),
+}
+
+// builtinStatements are the keywords that cannot be registered again.
+var builtinStatements = []Token{POLL, DOWNLOAD, SQLEXECUTE, REFRESH, SET}
+
+// RegisterKeyword returns the token of a keyword, adding it to the scanner if it is not known yet.
+// Keywords are case insensitive and must only contain letters, digits and underscores.
+func RegisterKeyword(keyword string) (Token, error) {
+	name := strings.ToUpper(keyword)
+	if name == "" {
+		return ILLEGAL, fmt.Errorf("keyword cannot be empty")
+	}
+	for _, ch := range name {
+		if ch == '.' || (!isLetter(ch) && !isDigit(ch) && ch != '_') {
+			return ILLEGAL, fmt.Errorf("invalid keyword %s", keyword)
+		}
+	}
+	if tok := lookupKeyword(name); tok != IDENT {
+		return tok, nil
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if tok, ok := registry.keywords[name]; ok {
+		return tok, nil
+	}
+	tok := registry.next
+	registry.next++
+	registry.keywords[name] = tok
+	registry.names[tok] = name
+	return tok, nil
+}
+
+// RegisterStatement adds a statement starting with keyword to the parser.
+// parse is called by ParseStatement whenever keyword starts a statement.
+// Built-in statements cannot be replaced and a keyword can only be registered once.
+func RegisterStatement(keyword string, parse ParseFunc) error {
+	if parse == nil {
+		return fmt.Errorf("parse function of %s cannot be nil", keyword)
+	}
+	tok, err := RegisterKeyword(keyword)
+	if err != nil {
+		return err
+	}
+	name := tok.String()
+	for _, b := range builtinStatements {
+		if tok == b {
+			return fmt.Errorf("%s is a built-in statement", name)
+		}
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.statements[tok]; ok {
+		return fmt.Errorf("statement %s is already registered", name)
+	}
+	registry.statements[tok] = parse
+	registry.order = append(registry.order, name)
+	return nil
+}
+
+// lookupRegisteredKeyword returns the token of a keyword added with RegisterKeyword, or IDENT.
+func lookupRegisteredKeyword(name string) Token {
+	registry.RLock()
+	defer registry.RUnlock()
+	if tok, ok := registry.keywords[name]; ok {
+		return tok
+	}
+	return IDENT
+}
+
+func lookupRegisteredStatement(tok Token) ParseFunc {
+	registry.RLock()
+	defer registry.RUnlock()
+	return registry.statements[tok]
+}
+
+func registeredStatementNames() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	return append([]string(nil), registry.order...)
+}
+
+func registeredTokenName(tok Token) string {
+	registry.RLock()
+	defer registry.RUnlock()
+	return registry.names[tok]
+}
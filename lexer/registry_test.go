@@ -0,0 +1,113 @@
+package lexer_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jerminb/nestor/lexer"
+)
+
+type notifyStatement struct {
+	lexer.ExtensionStatement
+	Channel string
+	Message string
+}
+
+func (s *notifyStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("NOTIFY ")
+	_, _ = buf.WriteString(s.Channel)
+	_, _ = buf.WriteString(" WITH ")
+	_, _ = buf.WriteString(s.Message)
+	_, _ = buf.WriteString(s.BaseStatement.String())
+	return buf.String()
+}
+
+var registerNotify sync.Once
+
+func parseNotifyStatement(p *lexer.Parser) (lexer.Statement, error) {
+	with, err := lexer.RegisterKeyword("WITH")
+	if err != nil {
+		return nil, err
+	}
+	stmt := &notifyStatement{}
+	tok, lit := p.ScanIgnoreWhitespace()
+	if tok != lexer.IDENT {
+		return nil, p.NewParseError(lexer.Tokstr(tok, lit), []string{"CHANNEL"})
+	}
+	stmt.Channel = lit
+	if tok, lit := p.ScanIgnoreWhitespace(); tok != with {
+		return nil, p.NewParseError(lexer.Tokstr(tok, lit), []string{"WITH"})
+	}
+	tok, lit = p.ScanIgnoreWhitespace()
+	if tok != lexer.IDENT {
+		return nil, p.NewParseError(lexer.Tokstr(tok, lit), []string{"MESSAGE"})
+	}
+	stmt.Message = lit
+	if err := p.ParseBaseStatement(&stmt.BaseStatement); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func setupNotifyStatement(t *testing.T) {
+	registerNotify.Do(func() {
+		if err := lexer.RegisterStatement("notify", parseNotifyStatement); err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+	})
+}
+
+func TestRegisterStatement(t *testing.T) {
+	setupNotifyStatement(t)
+	q, err := lexer.NewParser(strings.NewReader(`notify "ops" with "deployed" timeout "5s" &; download from "http://foo.bar" save to "/tmp/foo"`)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	expected := &notifyStatement{
+		Channel: "ops",
+		Message: "deployed",
+	}
+	expected.IsBackground = true
+	expected.Timeout = "5s"
+	if !reflect.DeepEqual(q.Statements[0], expected) {
+		t.Fatalf("expected %v. got %v", expected, q.Statements[0])
+	}
+	if q.Statements[0].String() != `NOTIFY ops WITH deployed TIMEOUT "5s" &` {
+		t.Fatalf(`expected NOTIFY ops WITH deployed TIMEOUT "5s" &. got %s`, q.Statements[0].String())
+	}
+}
+
+func TestRegisterStatementErrors(t *testing.T) {
+	setupNotifyStatement(t)
+	if err := lexer.RegisterStatement("NOTIFY", parseNotifyStatement); err == nil {
+		t.Fatalf("expected error for duplicate statement. got nil")
+	}
+	if err := lexer.RegisterStatement("poll", parseNotifyStatement); err == nil {
+		t.Fatalf("expected error for built-in statement. got nil")
+	}
+	if err := lexer.RegisterStatement("bad keyword", parseNotifyStatement); err == nil {
+		t.Fatalf("expected error for invalid keyword. got nil")
+	}
+	_, err := lexer.NewParser(strings.NewReader(`notify "ops" "deployed"`)).ParseStatement()
+	if err == nil || !strings.Contains(err.Error(), "expected WITH") {
+		t.Fatalf("expected WITH error. got %v", err)
+	}
+	_, err = lexer.NewParser(strings.NewReader(`foo "bar"`)).ParseStatement()
+	if err == nil || !strings.Contains(err.Error(), "NOTIFY") {
+		t.Fatalf("expected registered statements in error. got %v", err)
+	}
+}
+
+func TestRegisterKeywordBuiltin(t *testing.T) {
+	tok, err := lexer.RegisterKeyword("every")
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if tok != lexer.EVERY {
+		t.Fatalf("expected EVERY. got %v", tok)
+	}
+}
@@ -16,6 +16,11 @@ const eof = rune(0)
 //Scanner represents a lexical scanner
 type Scanner struct {
 	r *bufio.Reader
+	// pos is the position of the next rune, prev is restored by unread
+	pos  Pos
+	prev Pos
+	// tokPos is the position of the first rune of the last scanned token
+	tokPos Pos
 }
 
 // NewScanner returns a new instance of Scanner.
@@ -23,10 +28,26 @@ func NewScanner(r io.Reader) *Scanner {
 	return &Scanner{r: bufio.NewReader(r)}
 }
 
+// ReadRune reads the next rune from the bufferred reader and advances the scanner position.
+func (s *Scanner) ReadRune() (ch rune, size int, err error) {
+	ch, size, err = s.r.ReadRune()
+	s.prev = s.pos
+	if err != nil {
+		return ch, size, err
+	}
+	if ch == '\n' {
+		s.pos.Line++
+		s.pos.Char = 0
+	} else {
+		s.pos.Char++
+	}
+	return ch, size, nil
+}
+
 // read reads the next rune from the bufferred reader.
 // Returns the rune(0) if an error occurs (or io.EOF is returned).
 func (s *Scanner) read() rune {
-	ch, _, err := s.r.ReadRune()
+	ch, _, err := s.ReadRune()
 	if err != nil {
 		return eof
 	}
@@ -34,19 +55,27 @@ func (s *Scanner) read() rune {
 }
 
 // unread places the previously read rune back on the reader.
-func (s *Scanner) unread() { _ = s.r.UnreadRune() }
+func (s *Scanner) unread() {
+	_ = s.r.UnreadRune()
+	s.pos = s.prev
+}
+
+// Pos returns the position of the last scanned token.
+func (s *Scanner) Pos() Pos { return s.tokPos }
 
 // Scan returns the next token and literal value.
 func (s *Scanner) Scan() (tok Token, lit string) {
+	s.tokPos = s.pos
+
 	// Read the next rune.
 	ch := s.read()
 
 	// If we see whitespace then consume all contiguous whitespace.
-	// If we see a letter then consume as an ident or reserved word.
+	// If we see a letter or a digit then consume as an ident or reserved word.
 	if isWhitespace(ch) {
 		s.unread()
 		return s.scanWhitespace()
-	} else if isLetter(ch) {
+	} else if isLetter(ch) || isDigit(ch) {
 		s.unread()
 		return s.scanIdent()
 	}
@@ -114,7 +143,7 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 }
 
 // scanString reads a quoted string from a rune reader.
-func scanString(r *bufio.Reader) (string, error) {
+func scanString(r io.RuneReader) (string, error) {
 	var buf bytes.Buffer
 	ending, _, err := r.ReadRune()
 	if err != nil {
@@ -159,7 +188,7 @@ func scanString(r *bufio.Reader) (string, error) {
 // Quote characters can be consumed if they're first escaped with a backslash.
 func (s *Scanner) scanString() (tok Token, lit string) {
 	var err error
-	lit, err = scanString(s.r)
+	lit, err = scanString(s)
 	if err == errBadString {
 		return BADSTRING, lit
 	} else if err == errBadEscape {
@@ -198,6 +227,7 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
 
 	// Read every ident character into the buffer.
 	// Non-ident characters and EOF will cause the loop to exit.
+	quoted := false
 	for {
 		if ch := s.read(); ch == eof {
 			break
@@ -208,6 +238,7 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
 				return tok0, lit0
 			}
 			buf.WriteString(lit0)
+			quoted = true
 			break
 		} else if ch == '{' {
 			s.unread()
@@ -216,6 +247,7 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
 				return tok0, lit0
 			}
 			buf.WriteString(lit0)
+			quoted = true
 			break
 		} else if !isLetter(ch) && !isDigit(ch) && ch != '_' {
 			s.unread()
@@ -225,36 +257,133 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
 		}
 	}
 
+	// Quoted literals are never keywords.
+	if quoted {
+		return IDENT, buf.String()
+	}
+
 	// If the string matches a keyword then return that keyword.
-	switch strings.ToUpper(buf.String()) {
+	// Otherwise return as a regular identifier.
+	name := strings.ToUpper(buf.String())
+	if tok := lookupKeyword(name); tok != IDENT {
+		return tok, buf.String()
+	}
+	return lookupRegisteredKeyword(name), buf.String()
+}
+
+// lookupKeyword returns the token of a built-in keyword, or IDENT.
+func lookupKeyword(name string) Token {
+	switch name {
 	case "POLL":
-		return POLL, buf.String()
+		return POLL
 	case "EVERY":
-		return EVERY, buf.String()
+		return EVERY
 	case "AFTER":
-		return AFTER, buf.String()
+		return AFTER
 	case "DOWNLOAD":
-		return DOWNLOAD, buf.String()
+		return DOWNLOAD
 	case "FROM":
-		return FROM, buf.String()
+		return FROM
 	case "SAVE":
-		return SAVE, buf.String()
+		return SAVE
 	case "TO":
-		return TO, buf.String()
+		return TO
 	case "TIMES":
-		return TIMES, buf.String()
+		return TIMES
 	case "SQLEXECUTE":
-		return SQLEXECUTE, buf.String()
+		return SQLEXECUTE
 	case "INTO":
-		return INTO, buf.String()
+		return INTO
 	case "REFRESH":
-		return REFRESH, buf.String()
+		return REFRESH
 	case "TOKEN":
-		return TOKEN, buf.String()
+		return TOKEN
 	case "CERTIFICATE":
-		return CERTIFICATE, buf.String()
+		return CERTIFICATE
+	case "SET":
+		return SET
+	case "TIMEOUT":
+		return TIMEOUT
+	case "SCHEDULE":
+		return SCHEDULE
+	case "AT":
+		return AT
+	case "STARTUP":
+		return STARTUP
+	case "EXPECT":
+		return EXPECT
+	case "STATUS":
+		return STATUS
+	case "BODY":
+		return BODY
+	case "JSON":
+		return JSON
+	case "METHOD":
+		return METHOD
+	case "HEADER":
+		return HEADER
+	case "AUTH":
+		return AUTH
+	case "BASIC":
+		return BASIC
+	case "BEARER":
+		return BEARER
+	case "BACKOFF":
+		return BACKOFF
+	case "FIXED":
+		return FIXED
+	case "EXPONENTIAL":
+		return EXPONENTIAL
+	case "JITTER":
+		return JITTER
+	case "MAX":
+		return MAX
+	case "DEADLINE":
+		return DEADLINE
+	case "QUORUM":
+		return QUORUM
+	case "ALL":
+		return ALL
+	case "ANY":
+		return ANY
+	case "UNTIL":
+		return UNTIL
+	case "DOWN":
+		return DOWN
+	case "VERIFY":
+		return VERIFY
+	case "SHA256":
+		return SHA256
+	case "SHA512":
+		return SHA512
+	case "SIGNATURE":
+		return SIGNATURE
+	case "WITH":
+		return WITH
+	case "EXTRACT":
+		return EXTRACT
+	case "STRIP":
+		return STRIP
+	case "OR":
+		return OR
+	case "FASTEST":
+		return FASTEST
+	case "MANIFEST":
+		return MANIFEST
+	case "ON":
+		return ON
+	case "ERROR":
+		return ERROR
+	case "CONTINUE":
+		return CONTINUE
+	case "ABORT":
+		return ABORT
+	case "MISSED":
+		return MISSED
+	case "SKIP":
+		return SKIP
+	case "ONCE":
+		return ONCE
 	}
-
-	// Otherwise return as a regular identifier.
-	return IDENT, buf.String()
+	return IDENT
 }
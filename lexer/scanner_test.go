@@ -21,6 +21,8 @@ func TestScanner(t *testing.T) {
 		{"REFRESH", lexer.REFRESH, "REFRESH"},
 		{"TOKEN", lexer.TOKEN, "TOKEN"},
 		{"CERTIFICATE", lexer.CERTIFICATE, "CERTIFICATE"},
+		{"SET", lexer.SET, "SET"},
+		{"TIMEOUT", lexer.TIMEOUT, "TIMEOUT"},
 		{"    ", lexer.WS, "    "},
 		{"\"foo\"", lexer.IDENT, "foo"},
 		{"\"foo", lexer.BADSTRING, "foo"},
@@ -40,3 +42,27 @@ func TestScanner(t *testing.T) {
 		}
 	}
 }
+
+func TestScannerPos(t *testing.T) {
+	scanner := lexer.NewScanner(strings.NewReader("poll \"foo\"\n  every"))
+	var tests = []struct {
+		want lexer.Token
+		pos  lexer.Pos
+	}{
+		{lexer.POLL, lexer.Pos{Line: 0, Char: 0}},
+		{lexer.WS, lexer.Pos{Line: 0, Char: 4}},
+		{lexer.IDENT, lexer.Pos{Line: 0, Char: 5}},
+		{lexer.WS, lexer.Pos{Line: 0, Char: 10}},
+		{lexer.EVERY, lexer.Pos{Line: 1, Char: 2}},
+		{lexer.EOF, lexer.Pos{Line: 1, Char: 7}},
+	}
+	for _, c := range tests {
+		tok, _ := scanner.Scan()
+		if tok != c.want {
+			t.Fatalf("expected %s . got %s", c.want.String(), tok)
+		}
+		if scanner.Pos() != c.pos {
+			t.Errorf("expected %v for %s. got %v", c.pos, tok, scanner.Pos())
+		}
+	}
+}
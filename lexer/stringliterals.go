@@ -41,6 +41,7 @@ const (
 	RIGHTPARENTHESIS // )
 
 	// Keywords
+	keywordBeg
 	POLL
 	EVERY
 	AFTER
@@ -54,6 +55,50 @@ const (
 	REFRESH
 	TOKEN
 	CERTIFICATE
+	// The keywords below are contextual; they are only reserved where a clause may start and remain
+	// valid unquoted values, see isContextual.
+	contextualBeg
+	SET
+	TIMEOUT
+	SCHEDULE
+	AT
+	STARTUP
+	EXPECT
+	STATUS
+	BODY
+	JSON
+	METHOD
+	HEADER
+	AUTH
+	BASIC
+	BEARER
+	BACKOFF
+	FIXED
+	EXPONENTIAL
+	JITTER
+	MAX
+	DEADLINE
+	QUORUM
+	ALL
+	ANY
+	UNTIL
+	DOWN
+	VERIFY
+	SHA256
+	SHA512
+	SIGNATURE
+	WITH
+	EXTRACT
+	STRIP
+	FASTEST
+	MANIFEST
+	ON
+	ERROR
+	CONTINUE
+	ABORT
+	MISSED
+	SKIP
+	ONCE
 )
 
 var tokens = [...]string{
@@ -97,6 +142,58 @@ var tokens = [...]string{
 	REFRESH:     "REFRESH",
 	TOKEN:       "TOKEN",
 	CERTIFICATE: "CERTIFICATE",
+	SET:         "SET",
+	TIMEOUT:     "TIMEOUT",
+	SCHEDULE:    "SCHEDULE",
+	AT:          "AT",
+	STARTUP:     "STARTUP",
+	EXPECT:      "EXPECT",
+	STATUS:      "STATUS",
+	BODY:        "BODY",
+	JSON:        "JSON",
+	METHOD:      "METHOD",
+	HEADER:      "HEADER",
+	AUTH:        "AUTH",
+	BASIC:       "BASIC",
+	BEARER:      "BEARER",
+	BACKOFF:     "BACKOFF",
+	FIXED:       "FIXED",
+	EXPONENTIAL: "EXPONENTIAL",
+	JITTER:      "JITTER",
+	MAX:         "MAX",
+	DEADLINE:    "DEADLINE",
+	QUORUM:      "QUORUM",
+	ALL:         "ALL",
+	ANY:         "ANY",
+	UNTIL:       "UNTIL",
+	DOWN:        "DOWN",
+	VERIFY:      "VERIFY",
+	SHA256:      "SHA256",
+	SHA512:      "SHA512",
+	SIGNATURE:   "SIGNATURE",
+	WITH:        "WITH",
+	EXTRACT:     "EXTRACT",
+	STRIP:       "STRIP",
+	FASTEST:     "FASTEST",
+	MANIFEST:    "MANIFEST",
+	ON:          "ON",
+	ERROR:       "ERROR",
+	CONTINUE:    "CONTINUE",
+	ABORT:       "ABORT",
+	MISSED:      "MISSED",
+	SKIP:        "SKIP",
+	ONCE:        "ONCE",
+}
+
+// isKeyword reports whether tok is a word reserved by the language or by a registered statement.
+func (tok Token) isKeyword() bool {
+	return tok == AND || tok == OR || tok > keywordBeg
+}
+
+// isContextual reports whether tok is a keyword added to the language after its first statements,
+// or by a registered statement. Contextual keywords are accepted wherever a value is expected.
+func (tok Token) isContextual() bool {
+	return tok == AND || tok == OR || tok > contextualBeg
 }
 
 // String returns the string representation of the token.
@@ -104,7 +201,7 @@ func (tok Token) String() string {
 	if tok >= 0 && tok < Token(len(tokens)) {
 		return tokens[tok]
 	}
-	return ""
+	return registeredTokenName(tok)
 }
 
 func isWhitespace(ch rune) bool {
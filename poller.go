@@ -1,10 +1,11 @@
 package nestor
 
 import (
+	"context"
 	"errors"
-	"net"
+	"io"
 	"net/http"
-	"reflect"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -17,96 +18,221 @@ const (
 	DefaultDialTimeout time.Duration = time.Second * 5
 	//DefaultTLSHandshakeTimeout default tls handshake timeout
 	DefaultTLSHandshakeTimeout time.Duration = 5 * time.Second
+	//DefaultMaxBackoff default cap of growing delays between polls
+	DefaultMaxBackoff time.Duration = time.Minute
 )
 
 //ErrorMaxCountExceeded is the error returned when error count for a pollee exceeds its max error count
 var ErrorMaxCountExceeded = errors.New("exceeded max error count")
 
+//ErrorDeadlineExceeded is the error returned when a poller gives up after its deadline
+var ErrorDeadlineExceeded = errors.New("exceeded poll deadline")
+
 //PollResponse is the message that is returned by polled through pollResponseChannel
 type PollResponse struct {
 	ResponseStatus string
-	Error          error
+	// Succeeded is set when the response met all expectations of the pollee
+	Succeeded bool
+	Error     error
 }
 
 //Pollee defines an url to monitor for a specific response with an error count monitor.
-//Connection timeouts are counted as error.
+//Connection timeouts are counted as error. Expectations must all be met by a response for the poll to succeed.
+//A Pollee sends one request at a time and must not be polled concurrently.
+//UntilDown inverts the pollee when set; a poll succeeds once the url is unreachable or does not
+//meet expectations for UntilDown consecutive polls. Only the polls finding the url up are then counted as errors.
 type Pollee struct {
 	url                 string
 	ErrorCount          int
 	MaxErrorCount       int
 	SuccessStatus       string
-	request             *http.Request
-	client              *http.Client
+	Expectations        []*Expectation
+	UntilDown           int
+	downCount           int
+	ctx                 context.Context
+	probe               Probe
 	pollResponseChannel chan<- *PollResponse
 }
 
-func (p *Pollee) sendPollResponse(responseStatus string, err error) {
-	p.pollResponseChannel <- &PollResponse{
-		ResponseStatus: responseStatus,
-		Error:          err,
-	}
-}
-
 // Poll executes an httpVerb request for url
 // and returns the HTTP status string or an error asyncronously through
 // pollResponseChannel
 func (p *Pollee) Poll() {
-	log.Infof("ErrorCount %d, MaxErrorCount %d", p.ErrorCount, p.MaxErrorCount)
+	p.pollResponseChannel <- p.poll(p.ctx)
+}
+
+// poll probes the url once and returns the response. Every unsuccessful poll, including
+// transport errors, is counted until MaxErrorCount is reached, see pollDown for inverted pollees.
+func (p *Pollee) poll(ctx context.Context) *PollResponse {
+	log.Debugf("ErrorCount %d, MaxErrorCount %d", p.ErrorCount, p.MaxErrorCount)
 	if p.ErrorCount >= p.MaxErrorCount {
-		p.sendPollResponse("", ErrorMaxCountExceeded)
-		return
+		return &PollResponse{Error: ErrorMaxCountExceeded}
 	}
 	p.ErrorCount++
 	log.Debugf("Polling %s ....", p.url)
-	resp, err := p.client.Do(p.request)
+	status, succeeded, err := p.probe.Probe(ctx)
+	if p.UntilDown > 0 {
+		return p.pollDown(status, succeeded, err)
+	}
 	if err != nil {
 		log.Debugf("Failed polling %s with %v", p.url, err)
-		p.sendPollResponse("", err)
-	}
-	respStatus := ""
-	if resp != nil {
-		defer resp.Body.Close()
-		respStatus = resp.Status
-		log.Debugf("Response statue %s", respStatus)
+		return &PollResponse{Error: err}
 	}
-	if respStatus == p.SuccessStatus {
-		log.Debugf("Expected %s. Got %s", p.SuccessStatus, respStatus)
+	if succeeded {
+		log.Debugf("Expected %v. Got %s", p.Expectations, status)
 		p.ErrorCount = 0
 	}
-	p.sendPollResponse(respStatus, nil)
+	return &PollResponse{
+		ResponseStatus: status,
+		Succeeded:      succeeded,
+	}
+}
+
+// pollDown returns the response of an inverted poll. A transport error is the expected outcome
+// and is therefore not returned.
+func (p *Pollee) pollDown(status string, up bool, err error) *PollResponse {
+	if err == nil && up {
+		log.Debugf("%s is still up with %s", p.url, status)
+		p.downCount = 0
+		return &PollResponse{ResponseStatus: status}
+	}
+	if err != nil {
+		status = err.Error()
+	}
+	// the url being down is progress rather than an error
+	p.ErrorCount--
+	p.downCount++
+	log.Debugf("%s is down with %s (%d/%d)", p.url, status, p.downCount, p.UntilDown)
+	if p.downCount < p.UntilDown {
+		return &PollResponse{ResponseStatus: status}
+	}
+	p.ErrorCount = 0
+	return &PollResponse{
+		ResponseStatus: status,
+		Succeeded:      true,
+	}
+}
+
+//Close releases the resources of the pollee's probe
+func (p *Pollee) Close() {
+	p.probe.Close()
 }
 
 //NewPollee is a constructor for Pollee class
 func NewPollee(url string, httpMethod string, maxErrorCount int, successStatus string, responseChannel chan<- *PollResponse) (*Pollee, error) {
-	var netTransport = &http.Transport{
-		Dial: (&net.Dialer{
-			Timeout: DefaultDialTimeout,
-		}).Dial,
-		TLSHandshakeTimeout: DefaultTLSHandshakeTimeout,
+	return NewPolleeWithContext(context.Background(), url, httpMethod, maxErrorCount, successStatus, responseChannel)
+}
+
+//NewPolleeWithContext is a constructor for Pollee class whose requests are cancelled with ctx
+func NewPolleeWithContext(ctx context.Context, url string, httpMethod string, maxErrorCount int, successStatus string, responseChannel chan<- *PollResponse) (*Pollee, error) {
+	pe, err := NewPolleeWithExpectations(ctx, url, httpMethod, maxErrorCount, []*Expectation{NewStatusExpectation(successStatus)}, responseChannel)
+	if err != nil {
+		return nil, err
 	}
-	var netClient = &http.Client{
-		Timeout:   DefaultConnectionTimeout,
-		Transport: netTransport,
+	pe.SuccessStatus = successStatus
+	return pe, nil
+}
+
+//NewPolleeWithExpectations is a constructor for Pollee class that succeeds when a response meets all expectations
+func NewPolleeWithExpectations(ctx context.Context, url string, httpMethod string, maxErrorCount int, expectations []*Expectation, responseChannel chan<- *PollResponse) (*Pollee, error) {
+	req, err := NewPollRequest(ctx, httpMethod, url, nil, "")
+	if err != nil {
+		return nil, err
 	}
-	req, err := http.NewRequest(httpMethod, url, nil)
+	return NewPolleeWithRequest(req, maxErrorCount, expectations, responseChannel)
+}
+
+//NewPollRequest returns a request with headers and an optional body to poll url with.
+// A Host header replaces the host of the request.
+func NewPollRequest(ctx context.Context, httpMethod string, url string, header http.Header, body string) (*http.Request, error) {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(httpMethod, url, r)
 	if err != nil {
 		return nil, err
 	}
+	setRequestHeader(req, header)
+	return req.WithContext(ctx), nil
+}
 
+// setRequestHeader adds the values of headers to req. A Host header sets req.Host instead.
+func setRequestHeader(req *http.Request, headers ...http.Header) {
+	for _, header := range headers {
+		for name, values := range header {
+			if http.CanonicalHeaderKey(name) == "Host" && len(values) > 0 {
+				req.Host = values[0]
+				continue
+			}
+			for _, v := range values {
+				req.Header.Add(name, v)
+			}
+		}
+	}
+}
+
+//NewPolleeWithRequest is a constructor for Pollee class that probes req's url on every poll.
+// See NewProbe for the supported url schemes. Polls are cancelled with req's context.
+func NewPolleeWithRequest(req *http.Request, maxErrorCount int, expectations []*Expectation, responseChannel chan<- *PollResponse) (*Pollee, error) {
+	probe, err := NewProbe(req, expectations)
+	if err != nil {
+		return nil, err
+	}
+	pe := NewPolleeWithProbe(req.Context(), req.URL.String(), probe, maxErrorCount, responseChannel)
+	pe.Expectations = expectations
+	return pe, nil
+}
+
+//NewPolleeWithProbe is a constructor for Pollee class that calls probe on every poll.
+// Polls are cancelled with ctx.
+func NewPolleeWithProbe(ctx context.Context, url string, probe Probe, maxErrorCount int, responseChannel chan<- *PollResponse) *Pollee {
 	return &Pollee{
 		url:                 url,
 		ErrorCount:          0,
 		MaxErrorCount:       maxErrorCount,
-		SuccessStatus:       successStatus,
-		client:              netClient,
-		request:             req,
+		ctx:                 ctx,
+		probe:               probe,
 		pollResponseChannel: responseChannel,
-	}, nil
+	}
 }
 
-//Poller monitors a Pollee object on fixed intervals and returns success if
+//PollerParameters are the parameters of Poller's Execute
+type PollerParameters struct {
+	URL           string
+	HTTPMethod    string
+	Header        http.Header
+	Body          string
+	MaxErrorCount int
+	SuccessStatus string
+	// Expectations replace SuccessStatus when set
+	Expectations []*Expectation
+	Interval     time.Duration
+	// Backoff replaces the fixed Interval when set
+	Backoff Backoff
+	// URLs are polled concurrently along with URL
+	URLs []string
+	// Quorum is the count of endpoints that must succeed. Zero means all of them.
+	Quorum int
+}
+
+//PollerPayload is the payload of a poll result
+type PollerPayload struct {
+	// Succeeded is set when the success status was received before max error count was reached,
+	// by a quorum of the endpoints of a multi-endpoint poll
+	Succeeded bool
+	Endpoints []*EndpointStatus
+}
+
+//Poller monitors a Pollee object, one request at a time, and returns success if
 // if success status is achieved or failure if max error count of pollee is expired
 type Poller struct {
+	// InitialWaitTime delays the first poll
+	InitialWaitTime time.Duration
+	// Deadline gives up monitoring after a duration, including InitialWaitTime, when set
+	Deadline time.Duration
+	// UntilDown waits for endpoints to be down for UntilDown consecutive polls instead of up, when set
+	UntilDown int
 }
 
 //Monitor is the blocking implementation of polling logic.
@@ -114,33 +240,154 @@ type Poller struct {
 // or max error count is reached (returned as false)
 // error is for internal error handling
 func (p *Poller) Monitor(url string, httpMethod string, maxErrorCount int, successStatus string, pollInterval time.Duration) (bool, error) {
-	ticker := time.NewTicker(pollInterval)
-	pollResponseChannel := make(chan *PollResponse)
-	pe, err := NewPollee(url, httpMethod, maxErrorCount, successStatus, pollResponseChannel)
+	return p.MonitorWithContext(context.Background(), url, httpMethod, maxErrorCount, successStatus, pollInterval)
+}
+
+//MonitorWithContext is Monitor that can be cancelled through ctx. The first poll happens
+// right after InitialWaitTime and then pollInterval after every unsuccessful poll.
+func (p *Poller) MonitorWithContext(ctx context.Context, url string, httpMethod string, maxErrorCount int, successStatus string, pollInterval time.Duration) (bool, error) {
+	return p.MonitorExpectations(ctx, url, httpMethod, maxErrorCount, []*Expectation{NewStatusExpectation(successStatus)}, pollInterval)
+}
+
+//MonitorExpectations is MonitorWithContext that succeeds once a response meets all expectations
+func (p *Poller) MonitorExpectations(ctx context.Context, url string, httpMethod string, maxErrorCount int, expectations []*Expectation, pollInterval time.Duration) (bool, error) {
+	req, err := NewPollRequest(ctx, httpMethod, url, nil, "")
 	if err != nil {
 		return false, err
 	}
+	return p.MonitorRequest(req, maxErrorCount, expectations, NewFixedBackoff(pollInterval))
+}
+
+//MonitorRequest is MonitorExpectations that probes req's url on every poll and waits for backoff between polls.
+// See NewProbe for the supported url schemes. It is cancelled through the context of req.
+func (p *Poller) MonitorRequest(req *http.Request, maxErrorCount int, expectations []*Expectation, backoff Backoff) (bool, error) {
+	probe, err := NewProbe(req, expectations)
+	if err != nil {
+		return false, err
+	}
+	return p.MonitorProbe(req.Context(), req.URL.String(), probe, maxErrorCount, backoff)
+}
+
+//MonitorProbe calls probe until it succeeds, max error count is reached, Deadline passes or ctx is done.
+// The next poll is only sent once the previous one returned. probe is closed on return.
+func (p *Poller) MonitorProbe(ctx context.Context, url string, probe Probe, maxErrorCount int, backoff Backoff) (bool, error) {
+	parent := ctx
+	if p.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, p.Deadline)
+		defer cancel()
+	}
+	pe := NewPolleeWithProbe(ctx, url, probe, maxErrorCount, nil)
+	pe.UntilDown = p.UntilDown
+	defer pe.Close()
+	succeeded, err := p.monitor(ctx, pe, backoff)
+	if err != nil && ctx.Err() != nil && parent.Err() == nil {
+		return false, ErrorDeadlineExceeded
+	}
+	return succeeded, err
+}
+
+// monitor polls pe from the calling goroutine until a poll succeeds, max error count is reached
+// or ctx is done. Unsuccessful polls, including transport errors, are retried after backoff.
+func (p *Poller) monitor(ctx context.Context, pe *Pollee, backoff Backoff) (bool, error) {
+	if p.InitialWaitTime > 0 {
+		log.Debugf("Waiting %v before polling %s", p.InitialWaitTime, pe.url)
+		if err := wait(ctx, p.InitialWaitTime); err != nil {
+			return false, err
+		}
+	}
+	var delay time.Duration
 	for {
-		select {
-		case <-ticker.C:
-			go pe.Poll()
-		case r := <-pollResponseChannel:
-			if r.Error != nil {
-				return false, r.Error
-			}
-			if r.ResponseStatus == pe.SuccessStatus {
-				return true, nil
-			}
+		r := pe.poll(ctx)
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		if r.Succeeded {
+			return true, nil
+		}
+		if pe.ErrorCount >= pe.MaxErrorCount {
+			return false, ErrorMaxCountExceeded
+		}
+		delay = backoff.Next(delay)
+		log.Debugf("Polling %s again in %v", pe.url, delay)
+		if err := wait(ctx, delay); err != nil {
+			return false, err
 		}
 	}
 }
 
-//Execute executes Poller's Monitor to implement Executable interface
-func (p *Poller) Execute(params ...interface{}) (result []reflect.Value, err error) {
-	return execute(p.Monitor, params...)
+// wait blocks for d or until ctx is done
+func wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+//Execute executes Poller's MonitorWithContext with typed parameters. Multiple endpoints are polled
+// concurrently and ErrorQuorumNotMet is returned when too few of them succeeded.
+func (p *Poller) Execute(ctx context.Context, params PollerParameters) (*PollerPayload, error) {
+	expectations := params.Expectations
+	if len(expectations) == 0 {
+		expectations = []*Expectation{NewStatusExpectation(params.SuccessStatus)}
+	}
+	backoff := params.Backoff
+	if backoff == nil {
+		backoff = NewFixedBackoff(params.Interval)
+	}
+	monitor := func(ctx context.Context, url string) (bool, error) {
+		req, err := NewPollRequest(ctx, params.HTTPMethod, url, params.Header, params.Body)
+		if err != nil {
+			return false, err
+		}
+		return p.MonitorRequest(req, params.MaxErrorCount, expectations, backoff)
+	}
+	if len(params.URLs) == 0 {
+		start := time.Now()
+		succeeded, err := monitor(ctx, params.URL)
+		status := &EndpointStatus{URL: params.URL, Status: StatusSucceeded, Duration: time.Since(start), Error: err}
+		if err != nil {
+			status.Status = StatusFailed
+			if ctx.Err() != nil {
+				status.Status = StatusCancelled
+			}
+		}
+		return &PollerPayload{
+			Succeeded: succeeded,
+			Endpoints: []*EndpointStatus{status},
+		}, err
+	}
+	urls := append([]string{params.URL}, params.URLs...)
+	quorum := params.Quorum
+	if quorum <= 0 || quorum > len(urls) {
+		quorum = len(urls)
+	}
+	succeeded, statuses := monitorQuorum(ctx, urls, quorum, monitor)
+	payload := &PollerPayload{
+		Succeeded: succeeded,
+		Endpoints: statuses,
+	}
+	if err := ctx.Err(); err != nil && !succeeded {
+		return payload, err
+	}
+	if !succeeded {
+		return payload, ErrorQuorumNotMet
+	}
+	return payload, nil
 }
 
 //NewPoller is constructor for Poller class
 func NewPoller() *Poller {
 	return &Poller{}
 }
+
+//NewPollerWithInitialWait is constructor for Poller class with a delay before the first poll
+func NewPollerWithInitialWait(initialWaitTime time.Duration) *Poller {
+	return &Poller{
+		InitialWaitTime: initialWaitTime,
+	}
+}
@@ -1,10 +1,20 @@
 package nestor_test
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/jerminb/nestor"
+	"github.com/jerminb/nestor/lexer"
 	"github.com/jerminb/nestor/testserver"
 )
 
@@ -124,16 +134,59 @@ func TestPoller(t *testing.T) {
 		testserver.MaxErrorCount(maxErrorCount-1))
 }
 
+func TestPollerInitialWait(t *testing.T) {
+	testserver.WithTestServer(t, func(url string) {
+		poller := nestor.NewPollerWithInitialWait(time.Second * 1)
+		start := time.Now()
+		res, err := poller.Monitor(url, "GET", 1, "200 OK", time.Second*5)
+		elapsed := time.Since(start)
+		if err != nil {
+			t.Fatalf("expected no error. got %v", err)
+		}
+		if !res {
+			t.Fatalf("expected success=true. got false")
+		}
+		if elapsed < time.Second*1 || elapsed >= time.Second*5 {
+			t.Fatalf("expected first poll right after 1 second. got %v", elapsed)
+		}
+	})
+}
+
+func TestPollerInitialWaitCancelled(t *testing.T) {
+	testserver.WithTestServer(t, func(url string) {
+		poller := nestor.NewPollerWithInitialWait(time.Minute * 10)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		start := time.Now()
+		res, err := poller.MonitorWithContext(ctx, url, "GET", 1, "200 OK", time.Second*1)
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected context.DeadlineExceeded. got %v", err)
+		}
+		if res {
+			t.Fatalf("expected success=false. got true")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("expected cancellation during initial wait. got %v", elapsed)
+		}
+	})
+}
+
 func TestPollerExecutablePositive(t *testing.T) {
 	maxErrorCount := 3
 	testserver.WithTestServer(t, func(url string) {
 		poller := nestor.NewPoller()
-		res, err := poller.Execute(url, "GET", maxErrorCount, "200 OK", time.Second*1)
+		res, err := poller.Execute(context.Background(), nestor.PollerParameters{
+			URL:           url,
+			HTTPMethod:    "GET",
+			MaxErrorCount: maxErrorCount,
+			SuccessStatus: "200 OK",
+			Interval:      time.Second * 1,
+		})
 		if err != nil {
 			t.Fatalf("expected no error. got %v", err)
 		}
-		if res == nil {
-			t.Fatalf("expected result. got nil")
+		if !res.Succeeded {
+			t.Fatalf("expected success=true. got false")
 		}
 	},
 		testserver.MaxErrorCount(maxErrorCount-1))
@@ -143,10 +196,290 @@ func TestPollerExecutableNegative(t *testing.T) {
 	maxErrorCount := 3
 	testserver.WithTestServer(t, func(url string) {
 		poller := nestor.NewPoller()
-		_, err := poller.Execute(url, "GET", maxErrorCount, "200 OK")
-		if err == nil {
-			t.Fatalf("expected error. got nil")
+		res, err := poller.Execute(context.Background(), nestor.PollerParameters{
+			URL:           url,
+			HTTPMethod:    "GET",
+			MaxErrorCount: maxErrorCount,
+			SuccessStatus: "200 OK",
+			Interval:      time.Millisecond * 100,
+		})
+		if err != nestor.ErrorMaxCountExceeded {
+			t.Fatalf("expected ErrorMaxCountExceeded. got %v", err)
+		}
+		if res.Succeeded {
+			t.Fatalf("expected success=false. got true")
 		}
 	},
 		testserver.MaxErrorCount(maxErrorCount))
 }
+
+func TestPollerExpectations(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := "DOWN"
+		if atomic.AddInt32(&requests, 1) > 2 {
+			status = "UP"
+		}
+		fmt.Fprintf(w, `{"status":%q}`, status)
+	}))
+	defer s.Close()
+	stmt, err := lexer.NewParser(strings.NewReader(fmt.Sprintf(`poll "%s" every "100ms" "5" times expect status 200 expect json "$.status" = "UP"`, s.URL))).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	res, err := nestor.ExecuteFromStatement(stmt)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if !res.Payload.(*nestor.PollerPayload).Succeeded {
+		t.Fatalf("expected success=true. got false")
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("expected 3 requests. got %d", n)
+	}
+}
+
+func TestPollerExpectationsNotMet(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"DOWN"}`)
+	}))
+	defer s.Close()
+	poller := nestor.NewPoller()
+	expectation, err := nestor.NewExpectation("BODY", "", "=~", `"status":"UP"`)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	res, err := poller.MonitorExpectations(context.Background(), s.URL, "GET", 2, []*nestor.Expectation{expectation}, time.Millisecond*100)
+	if err != nestor.ErrorMaxCountExceeded {
+		t.Fatalf("expected ErrorMaxCountExceeded. got %v", err)
+	}
+	if res {
+		t.Fatalf("expected success=false. got true")
+	}
+}
+
+func TestPollerRequestOptions(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || r.Header.Get("X-Probe") != "nestor" || r.Header.Get("Authorization") != "Bearer abc" || string(body) != `{"ready":true}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if atomic.AddInt32(&requests, 1) < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer s.Close()
+	stmt, err := lexer.NewParser(strings.NewReader(fmt.Sprintf(`poll "%s" method "post" header "X-Probe" "nestor" auth bearer "abc" body "{\"ready\":true}" every "100ms" "5" times`, s.URL))).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	res, err := nestor.ExecuteFromStatement(stmt)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if !res.Payload.(*nestor.PollerPayload).Succeeded {
+		t.Fatalf("expected success=true. got false")
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected 2 valid requests. got %d", n)
+	}
+}
+
+func TestPollerBasicAuth(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer s.Close()
+	stmt, err := lexer.NewParser(strings.NewReader(fmt.Sprintf(`poll "%s" auth basic "admin" "secret" every "100ms" "1" times`, s.URL))).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	res, err := nestor.ExecuteFromStatement(stmt)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if !res.Payload.(*nestor.PollerPayload).Succeeded {
+		t.Fatalf("expected success=true. got false")
+	}
+}
+
+func TestPollerBackoff(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+	poller := nestor.NewPoller()
+	poller.Deadline = time.Millisecond * 700
+	req, err := nestor.NewPollRequest(context.Background(), "GET", s.URL, nil, "")
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	// polls at 0, 100ms, 300ms and 700ms
+	res, err := poller.MonitorRequest(req, 100, []*nestor.Expectation{nestor.NewStatusExpectation("200")}, nestor.NewExponentialBackoff(time.Millisecond*100, time.Second))
+	if err != nestor.ErrorDeadlineExceeded {
+		t.Fatalf("expected ErrorDeadlineExceeded. got %v", err)
+	}
+	if res {
+		t.Fatalf("expected success=false. got true")
+	}
+	if n := atomic.LoadInt32(&requests); n < 3 || n > 4 {
+		t.Fatalf("expected 3 or 4 requests. got %d", n)
+	}
+}
+
+func TestPollerDeadline(t *testing.T) {
+	testserver.WithTestServer(t, func(url string) {
+		stmt, err := lexer.NewParser(strings.NewReader(fmt.Sprintf(`poll "%s" every "50ms" "100" times backoff jitter max "100ms" deadline "300ms"`, url))).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		res, err := nestor.ExecuteFromStatement(stmt)
+		if err != nestor.ErrorDeadlineExceeded {
+			t.Fatalf("expected ErrorDeadlineExceeded. got %v", err)
+		}
+		if res.Status != nestor.StatusFailed {
+			t.Fatalf("expected FAILED. got %v", res.Status)
+		}
+	}, testserver.StatusCodeStatic(http.StatusServiceUnavailable))
+}
+
+func TestPollerTransportErrorRetried(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := s.URL
+	s.Close()
+	poller := nestor.NewPoller()
+	res, err := poller.Monitor(url, "GET", 3, "200 OK", time.Millisecond*10)
+	if err != nestor.ErrorMaxCountExceeded {
+		t.Fatalf("expected ErrorMaxCountExceeded. got %v", err)
+	}
+	if res {
+		t.Fatalf("expected success=false. got true")
+	}
+}
+
+func TestPollerSingleRequestInFlight(t *testing.T) {
+	var inFlight, maxInFlight int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		if n > atomic.LoadInt32(&maxInFlight) {
+			atomic.StoreInt32(&maxInFlight, n)
+		}
+		// slower than the polling interval
+		time.Sleep(time.Millisecond * 30)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+	poller := nestor.NewPoller()
+	if _, err := poller.Monitor(s.URL, "GET", 5, "200 OK", time.Millisecond); err != nestor.ErrorMaxCountExceeded {
+		t.Fatalf("expected ErrorMaxCountExceeded. got %v", err)
+	}
+	if n := atomic.LoadInt32(&maxInFlight); n != 1 {
+		t.Fatalf("expected a single request in flight. got %d", n)
+	}
+}
+
+func TestConcurrentPollers(t *testing.T) {
+	testserver.WithTestServer(t, func(url string) {
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				poller := nestor.NewPoller()
+				if _, err := poller.Monitor(url, "HEAD", 20, "200 OK", time.Millisecond*10); err != nil {
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatalf("expected nil. got %v", err)
+		}
+	}, testserver.MaxErrorCount(15))
+}
+
+func TestPollerCancelledReleasesResources(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer s.Close()
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	poller := nestor.NewPoller()
+	if _, err := poller.MonitorWithContext(ctx, s.URL, "GET", 5, "200 OK", time.Millisecond*10); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded. got %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("expected at most %d goroutines. got %d", before, n)
+	}
+}
+
+func TestPollerUntilDown(t *testing.T) {
+	// up, down, up, down, down
+	codes := []int{200, 503, 200, 503, 503}
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		w.WriteHeader(codes[int(n-1)%len(codes)])
+	}))
+	defer s.Close()
+	res, err := executePoll(t, fmt.Sprintf(`poll "%s" every "10ms" "10" times until down "2" times`, s.URL))
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if !res.Payload.(*nestor.PollerPayload).Succeeded {
+		t.Fatalf("expected success=true. got false")
+	}
+	if n := atomic.LoadInt32(&requests); n != 5 {
+		t.Fatalf("expected 5 requests. got %d", n)
+	}
+}
+
+func TestPollerUntilDownUnreachable(t *testing.T) {
+	s := newStatusServer(http.StatusOK)
+	url := s.URL
+	s.Close()
+	res, err := executePoll(t, fmt.Sprintf(`poll "%s" every "10ms" "1" times until down`, url))
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if !res.Payload.(*nestor.PollerPayload).Succeeded {
+		t.Fatalf("expected success=true. got false")
+	}
+}
+
+func TestPollerUntilDownMoreThanRetries(t *testing.T) {
+	s := newStatusServer(http.StatusOK)
+	url := s.URL
+	s.Close()
+	res, err := executePoll(t, fmt.Sprintf(`poll "%s" every "10ms" "2" times until down "5" times`, url))
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if !res.Payload.(*nestor.PollerPayload).Succeeded {
+		t.Fatalf("expected success=true. got false")
+	}
+}
+
+func TestPollerUntilDownStillUp(t *testing.T) {
+	testserver.WithTestServer(t, func(url string) {
+		_, err := executePoll(t, fmt.Sprintf(`poll "%s" every "10ms" "3" times until down`, url))
+		if err != nestor.ErrorMaxCountExceeded {
+			t.Fatalf("expected ErrorMaxCountExceeded. got %v", err)
+		}
+	})
+}
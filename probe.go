@@ -0,0 +1,217 @@
+package nestor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	//ProbeSchemeTCP is the url scheme of probes connecting to host:port, e.g. tcp://db:5432
+	ProbeSchemeTCP string = "tcp"
+	//ProbeSchemeDNS is the url scheme of probes resolving a name, e.g. dns://db.internal
+	ProbeSchemeDNS string = "dns"
+	//ProbeSchemeUnix is the url scheme of HTTP probes through a Unix domain socket,
+	// e.g. unix:///var/run/app.sock:/health
+	ProbeSchemeUnix string = "unix"
+
+	// tcpProbeStatus is the status of a successful tcp probe
+	tcpProbeStatus string = "CONNECTED"
+)
+
+//Probe checks the readiness of an endpoint once per call
+type Probe interface {
+	// Probe returns the status observed and whether it is the expected one.
+	// An endpoint that cannot be reached is reported as an error.
+	Probe(ctx context.Context) (status string, succeeded bool, err error)
+	// Close releases the resources held by the probe
+	Close()
+}
+
+//NewProbe returns the probe of req's url. tcp:// probes succeed once a connection is established,
+// dns:// probes once the name resolves to at least one address and unix:// probes send req through
+// the Unix domain socket preceding the colon of the path, e.g. unix:///var/run/app.sock:/health.
+// Any other url is probed over HTTP. Expectations only apply to HTTP and Unix domain socket probes.
+func NewProbe(req *http.Request, expectations []*Expectation) (Probe, error) {
+	switch req.URL.Scheme {
+	case ProbeSchemeTCP:
+		if req.URL.Host == "" {
+			return nil, fmt.Errorf("invalid tcp probe %s: expected tcp://host:port", req.URL)
+		}
+		return &tcpProbe{address: req.URL.Host}, nil
+	case ProbeSchemeDNS:
+		if req.URL.Hostname() == "" {
+			return nil, fmt.Errorf("invalid dns probe %s: expected dns://name", req.URL)
+		}
+		return &dnsProbe{name: req.URL.Hostname()}, nil
+	case ProbeSchemeUnix:
+		return newUnixProbe(req, expectations)
+	}
+	return newHTTPProbe(req, expectations, newPollTransport()), nil
+}
+
+func newPollTransport() *http.Transport {
+	return &http.Transport{
+		Dial: (&net.Dialer{
+			Timeout: DefaultDialTimeout,
+		}).Dial,
+		TLSHandshakeTimeout: DefaultTLSHandshakeTimeout,
+	}
+}
+
+// httpProbe sends a request and evaluates expectations against its response
+type httpProbe struct {
+	request      *http.Request
+	expectations []*Expectation
+	client       *http.Client
+	transport    *http.Transport
+}
+
+func newHTTPProbe(req *http.Request, expectations []*Expectation, transport *http.Transport) *httpProbe {
+	return &httpProbe{
+		request:      req,
+		expectations: expectations,
+		client: &http.Client{
+			Timeout:   DefaultConnectionTimeout,
+			Transport: transport,
+		},
+		transport: transport,
+	}
+}
+
+// newUnixProbe returns an HTTP probe dialing the socket of a unix:///path/to/socket:/path url
+func newUnixProbe(req *http.Request, expectations []*Expectation) (*httpProbe, error) {
+	socket, path, err := parseUnixProbeURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.URL.Scheme, r.URL.Host, r.URL.Path, r.URL.RawPath = "http", "localhost", path, ""
+	dialer := &net.Dialer{
+		Timeout: DefaultDialTimeout,
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	return newHTTPProbe(r, expectations, transport), nil
+}
+
+// parseUnixProbeURL splits a unix:///path/to/socket:/path url into the socket and the request path.
+// The request path defaults to /.
+func parseUnixProbeURL(u *url.URL) (string, string, error) {
+	socket, path := u.Path, "/"
+	if i := strings.Index(socket, ":"); i >= 0 {
+		socket, path = socket[:i], socket[i+1:]
+	}
+	if u.Host != "" || socket == "" || !strings.HasPrefix(path, "/") {
+		return "", "", fmt.Errorf("invalid unix probe %s: expected unix:///path/to/socket:/path", u)
+	}
+	return socket, path, nil
+}
+
+func (p *httpProbe) Probe(ctx context.Context) (string, bool, error) {
+	req, err := p.newRequest(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	log.Debugf("Response status %s", resp.Status)
+	return resp.Status, p.isExpected(resp), nil
+}
+
+func (p *httpProbe) Close() {
+	p.transport.CloseIdleConnections()
+}
+
+// newRequest returns the request to send for a poll. A request with a body is cloned
+// with a fresh body since a body can only be read once.
+func (p *httpProbe) newRequest(ctx context.Context) (*http.Request, error) {
+	req := p.request.Clone(ctx)
+	if p.request.GetBody == nil {
+		return req, nil
+	}
+	body, err := p.request.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = body
+	return req, nil
+}
+
+// isExpected evaluates the expectations of the probe against a response.
+// The body is only read if an expectation needs it.
+func (p *httpProbe) isExpected(resp *http.Response) bool {
+	var body []byte
+	for _, e := range p.expectations {
+		if e.needsBody() {
+			b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxExpectedBodySize))
+			if err != nil {
+				log.Debugf("Failed reading response body of %s with %v", p.request.URL, err)
+				return false
+			}
+			body = b
+			break
+		}
+	}
+	for _, e := range p.expectations {
+		ok, err := e.Match(resp, body)
+		if err != nil {
+			log.Debugf("Failed evaluating %v for %s with %v", e, p.request.URL, err)
+			return false
+		}
+		if !ok {
+			log.Debugf("Expectation %v not met by %s", e, p.request.URL)
+			return false
+		}
+	}
+	return true
+}
+
+// tcpProbe succeeds once a tcp connection to address is established
+type tcpProbe struct {
+	address string
+}
+
+func (p *tcpProbe) Probe(ctx context.Context) (string, bool, error) {
+	dialer := &net.Dialer{
+		Timeout: DefaultDialTimeout,
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return "", false, err
+	}
+	conn.Close()
+	return tcpProbeStatus, true, nil
+}
+
+func (p *tcpProbe) Close() {}
+
+// dnsProbe succeeds once name resolves to at least one address
+type dnsProbe struct {
+	name string
+}
+
+func (p *dnsProbe) Probe(ctx context.Context) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultConnectionTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, p.name)
+	if err != nil {
+		return "", false, err
+	}
+	return strings.Join(addrs, ","), len(addrs) > 0, nil
+}
+
+func (p *dnsProbe) Close() {}
@@ -0,0 +1,104 @@
+package nestor_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jerminb/nestor"
+	"github.com/jerminb/nestor/lexer"
+)
+
+func executePoll(t *testing.T, s string) (*nestor.Result, error) {
+	stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if err := nestor.Validate(stmt); err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	return nestor.ExecuteFromStatement(stmt)
+}
+
+func TestTCPProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	addr := l.Addr().String()
+	res, err := executePoll(t, fmt.Sprintf(`poll "tcp://%s" every "10ms" "3" times`, addr))
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if !res.Payload.(*nestor.PollerPayload).Succeeded {
+		t.Fatalf("expected success=true. got false")
+	}
+	l.Close()
+	if _, err := executePoll(t, fmt.Sprintf(`poll "tcp://%s" every "10ms" "3" times`, addr)); err != nestor.ErrorMaxCountExceeded {
+		t.Fatalf("expected ErrorMaxCountExceeded. got %v", err)
+	}
+}
+
+func TestDNSProbe(t *testing.T) {
+	res, err := executePoll(t, `poll "dns://localhost" every "10ms" "3" times`)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if !res.Payload.(*nestor.PollerPayload).Succeeded {
+		t.Fatalf("expected success=true. got false")
+	}
+	if _, err := executePoll(t, `poll "dns://nestor.invalid" every "10ms" "2" times`); err != nestor.ErrorMaxCountExceeded {
+		t.Fatalf("expected ErrorMaxCountExceeded. got %v", err)
+	}
+}
+
+func TestUnixProbe(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "app.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"status":"UP"}`)
+	})}
+	go s.Serve(l)
+	defer s.Close()
+	res, err := executePoll(t, fmt.Sprintf(`poll "unix://%s:/health" every "10ms" "3" times expect json "status" = "UP"`, socket))
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if !res.Payload.(*nestor.PollerPayload).Succeeded {
+		t.Fatalf("expected success=true. got false")
+	}
+	if _, err := executePoll(t, fmt.Sprintf(`poll "unix://%s" every "10ms" "2" times`, socket)); err != nestor.ErrorMaxCountExceeded {
+		t.Fatalf("expected ErrorMaxCountExceeded. got %v", err)
+	}
+}
+
+func TestValidateProbes(t *testing.T) {
+	for _, s := range []string{
+		`poll "tcp://db" every "1s" "3" times`,
+		`poll "tcp://db:5432" every "1s" "3" times expect status 200`,
+		`poll "dns://db.internal" method "POST" every "1s" "3" times`,
+		`poll "unix://:/health" every "1s" "3" times`,
+		`poll "unix:///var/run/app.sock:health" every "1s" "3" times`,
+	} {
+		stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		if err := nestor.Validate(stmt); err == nil {
+			t.Fatalf("expected error for %s. got nil", s)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package nestor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jerminb/nestor/lexer"
+	log "github.com/sirupsen/logrus"
+)
+
+//QueryExecutor runs the statements of a lexer.Query in order.
+// Foreground statements are executed sequentially while background statements (&)
+// are launched concurrently and waited for at the end of the block.
+// Statements with a SCHEDULE clause always run in background and only return once
+// the context of the query is done.
+type QueryExecutor struct {
+	variables Variables
+}
+
+//ExecuteQuery walks the statements of a query block and returns one Result per started statement,
+// in statement order. Execution stops at the first failing foreground statement; background
+// statements that are already running are cancelled and waited for before returning.
+// ${name} references are resolved before any statement is executed. Values passed to
+// NewQueryExecutorWithVariables take precedence over SET statements, which take precedence
+// over the process environment.
+func (qe *QueryExecutor) ExecuteQuery(query *lexer.Query) ([]*Result, error) {
+	return qe.ExecuteQueryWithContext(context.Background(), query)
+}
+
+//ExecuteQueryWithContext is ExecuteQuery with a deadline or cancellation for the whole block.
+// No statement is started once ctx is done and running statements are cancelled.
+func (qe *QueryExecutor) ExecuteQueryWithContext(ctx context.Context, query *lexer.Query) ([]*Result, error) {
+	if query == nil {
+		return nil, fmt.Errorf("query is nil")
+	}
+	resolved, err := ResolveVariables(query, NewVariablesFromEnvironment(), qe.variables)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel, err := getStatementContext(ctx, resolved)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	return qe.executeQuery(ctx, resolved.(*lexer.Query))
+}
+
+func (qe *QueryExecutor) executeQuery(ctx context.Context, query *lexer.Query) ([]*Result, error) {
+	if query == nil {
+		return nil, fmt.Errorf("query is nil")
+	}
+	// cancels the background statements when a foreground statement fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	results := make([]*Result, len(query.Statements))
+	started := 0
+	var foregroundErr error
+	for i, stmt := range query.Statements {
+		if err := ctx.Err(); err != nil {
+			foregroundErr = err
+			break
+		}
+		started++
+		if isBackground(stmt) || getExecuteAt(stmt) == ExecuteAtScheduled {
+			log.Debugf("Starting %s in background", stmt.String())
+			wg.Add(1)
+			go func(i int, stmt lexer.Statement) {
+				defer wg.Done()
+				results[i] = qe.executeStatement(ctx, stmt)
+			}(i, stmt)
+			continue
+		}
+		results[i] = qe.executeStatement(ctx, stmt)
+		if results[i].Error != nil {
+			foregroundErr = results[i].Error
+			cancel()
+			break
+		}
+	}
+	wg.Wait()
+	results = results[:started]
+	if foregroundErr != nil {
+		return results, foregroundErr
+	}
+	for _, r := range results {
+		if r.Error != nil {
+			return results, r.Error
+		}
+	}
+	return results, nil
+}
+
+func (qe *QueryExecutor) executeStatement(ctx context.Context, stmt lexer.Statement) *Result {
+	log.Debugf("Executing %s", stmt.String())
+	r := executeStatement(ctx, stmt)
+	if r.Error != nil {
+		r.Error = fmt.Errorf("%s: %v", stmt.String(), r.Error)
+	}
+	return r
+}
+
+//Execute executes an already resolved query. Unlike ExecuteQuery, ${name} references are not resolved again.
+func (qe *QueryExecutor) Execute(ctx context.Context, query *lexer.Query) ([]*Result, error) {
+	return qe.executeQuery(ctx, query)
+}
+
+//NewQueryExecutor is the constructor for QueryExecutor class
+func NewQueryExecutor() *QueryExecutor {
+	return &QueryExecutor{}
+}
+
+//NewQueryExecutorWithVariables is the constructor for QueryExecutor class with variables
+// that override SET statements and the process environment
+func NewQueryExecutorWithVariables(variables map[string]string) *QueryExecutor {
+	return &QueryExecutor{
+		variables: variables,
+	}
+}
+
+type baser interface {
+	Base() *lexer.BaseStatement
+}
+
+func isBackground(stmt lexer.Statement) bool {
+	if b, ok := stmt.(baser); ok {
+		return b.Base().IsBackground
+	}
+	return false
+}
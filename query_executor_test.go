@@ -0,0 +1,173 @@
+package nestor_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jerminb/nestor"
+	"github.com/jerminb/nestor/lexer"
+	"github.com/jerminb/nestor/testserver"
+)
+
+func TestExecuteQuery(t *testing.T) {
+	nanos := time.Now().UnixNano()
+	first := fmt.Sprintf("/tmp/nestor_tests/%d_1", nanos)
+	second := fmt.Sprintf("/tmp/nestor_tests/%d_2", nanos)
+	defer os.Remove(first)
+	defer os.Remove(second)
+	testserver.WithTestServer(t, func(url string) {
+		s := fmt.Sprintf(`download from "%s" save to "%s" &; (download from "%s" save to "%s") &; poll "%s" every "1s" "1" times`, url, first, url, second, url)
+		q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+		if err != nil {
+			t.Fatalf("expected nil . got %v", err)
+		}
+		results, err := nestor.NewQueryExecutor().ExecuteQuery(q)
+		if err != nil {
+			t.Fatalf("expected no error. got %v", err)
+		}
+		if len(results) != 3 {
+			t.Fatalf("expected 3 results. got %d", len(results))
+		}
+		for _, r := range results {
+			if r.Error != nil {
+				t.Fatalf("expected nil. got %v", r.Error)
+			}
+			if r.Status != nestor.StatusSucceeded {
+				t.Fatalf("expected SUCCEEDED. got %v", r.Status)
+			}
+			if r.Duration <= 0 {
+				t.Fatalf("expected duration. got %v", r.Duration)
+			}
+		}
+		for _, f := range []string{first, second} {
+			if _, err := os.Stat(f); os.IsNotExist(err) {
+				t.Fatalf("expected file in %s. got nil", f)
+			}
+		}
+	})
+}
+
+func TestExecuteQueryStopsOnForegroundError(t *testing.T) {
+	s := `poll "http://foo.bar" every "2" "1" times; poll "http://foo.bar" every "2s" "1" times`
+	q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	results, err := nestor.NewQueryExecutor().ExecuteQuery(q)
+	if err == nil {
+		t.Fatalf("expected error. got nil")
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result. got %d", len(results))
+	}
+}
+
+func TestExecuteQueryCancelsBackgroundOnForegroundError(t *testing.T) {
+	s := `poll "tcp://127.0.0.1:1" every "1s" "1000" times &; poll "tcp://127.0.0.1:1" every "10ms" "1" times`
+	q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	done := make(chan struct{})
+	var results []*nestor.Result
+	go func() {
+		defer close(done)
+		results, err = nestor.NewQueryExecutor().ExecuteQuery(q)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the background poll to be cancelled. got a query still running")
+	}
+	if err == nil {
+		t.Fatalf("expected error. got nil")
+	}
+	if len(results) != 2 || results[0].Status != nestor.StatusCancelled {
+		t.Fatalf("expected a cancelled background poll. got %v", results)
+	}
+}
+
+func TestExecutionQuery(t *testing.T) {
+	nanos := time.Now().UnixNano()
+	filename := fmt.Sprintf("/tmp/nestor_tests/%d", nanos)
+	defer os.Remove(filename)
+	testserver.WithTestServer(t, func(url string) {
+		s := fmt.Sprintf(`(download from "%s" save to "%s" &) &`, url, filename)
+		stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil . got %v", err)
+		}
+		res, err := nestor.ExecuteFromStatement(stmt)
+		if err != nil {
+			t.Fatalf("expected no error. got %v", err)
+		}
+		results, ok := res.Payload.([]*nestor.Result)
+		if !ok {
+			t.Fatalf("expected []*Result. got %T", res.Payload)
+		}
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result. got %d", len(results))
+		}
+		if _, ok := results[0].Payload.(*nestor.DownloaderPayload); !ok {
+			t.Fatalf("expected *DownloaderPayload. got %T", results[0].Payload)
+		}
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			t.Fatalf("expected file in %s. got nil", filename)
+		}
+	})
+}
+
+func TestExecuteQueryDeadline(t *testing.T) {
+	testserver.WithTestServer(t, func(url string) {
+		s := fmt.Sprintf(`poll "%s" every "1s" "100" times &; poll "%s" every "1s" "100" times`, url, url)
+		q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+		if err != nil {
+			t.Fatalf("expected nil . got %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+		defer cancel()
+		start := time.Now()
+		results, err := nestor.NewQueryExecutor().ExecuteQueryWithContext(ctx, q)
+		if err == nil {
+			t.Fatalf("expected error. got nil")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("expected cancellation after 200ms. got %v", elapsed)
+		}
+		for _, r := range results {
+			if r.Error == nil {
+				t.Fatalf("expected every statement to be cancelled. got nil for %s", r.Statement.String())
+			}
+			if r.Status != nestor.StatusCancelled {
+				t.Fatalf("expected CANCELLED. got %v", r.Status)
+			}
+		}
+	},
+		testserver.MaxErrorCount(100))
+}
+
+func TestExecuteStatementTimeout(t *testing.T) {
+	testserver.WithTestServer(t, func(url string) {
+		s := fmt.Sprintf(`poll "%s" every "1s" "100" times timeout "200ms"`, url)
+		stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil . got %v", err)
+		}
+		start := time.Now()
+		res, err := nestor.ExecuteFromStatement(stmt)
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected context.DeadlineExceeded. got %v", err)
+		}
+		if res.Status != nestor.StatusCancelled {
+			t.Fatalf("expected CANCELLED. got %v", res.Status)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("expected timeout after 200ms. got %v", elapsed)
+		}
+	},
+		testserver.MaxErrorCount(100))
+}
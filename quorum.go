@@ -0,0 +1,75 @@
+package nestor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//ErrorQuorumNotMet is the error returned when too few endpoints of a poll succeeded
+var ErrorQuorumNotMet = errors.New("quorum not met")
+
+//EndpointStatus is the outcome of polling one endpoint of a multi-endpoint poll.
+// Endpoints still polling once the quorum is decided are cancelled.
+type EndpointStatus struct {
+	URL      string
+	Status   Status
+	Duration time.Duration
+	Error    error
+}
+
+// monitorFunc polls a single endpoint until it succeeds or gives up
+type monitorFunc func(ctx context.Context, url string) (bool, error)
+
+// monitorQuorum polls all urls concurrently until quorum of them succeeded or too many failed for
+// the quorum to be met. The remaining endpoints are then cancelled. It returns once every endpoint
+// has stopped polling.
+func monitorQuorum(ctx context.Context, urls []string, quorum int, monitor monitorFunc) (bool, []*EndpointStatus) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type outcome struct {
+		index     int
+		succeeded bool
+		err       error
+		duration  time.Duration
+	}
+	outcomes := make(chan outcome, len(urls))
+	start := time.Now()
+	for i, url := range urls {
+		go func(i int, url string) {
+			succeeded, err := monitor(ctx, url)
+			outcomes <- outcome{index: i, succeeded: succeeded, err: err, duration: time.Since(start)}
+		}(i, url)
+	}
+	statuses := make([]*EndpointStatus, len(urls))
+	succeeded, failed := 0, 0
+	decided := false
+	for range urls {
+		o := <-outcomes
+		s := &EndpointStatus{
+			URL:      urls[o.index],
+			Status:   StatusSucceeded,
+			Duration: o.duration,
+			Error:    o.err,
+		}
+		switch {
+		// endpoints stopped by the cancellation of ctx are neither succeeded nor failed
+		case o.err != nil && o.err == ctx.Err():
+			s.Status = StatusCancelled
+		case o.succeeded:
+			succeeded++
+		default:
+			s.Status = StatusFailed
+			failed++
+			log.Warnf("Polling %s failed: %v", s.URL, o.err)
+		}
+		statuses[o.index] = s
+		if !decided && (succeeded >= quorum || failed > len(urls)-quorum) {
+			decided = true
+			cancel()
+		}
+	}
+	return succeeded >= quorum, statuses
+}
@@ -0,0 +1,70 @@
+package nestor_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jerminb/nestor"
+)
+
+func newStatusServer(code int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}))
+}
+
+func TestPollQuorum(t *testing.T) {
+	up1, up2, down := newStatusServer(http.StatusOK), newStatusServer(http.StatusOK), newStatusServer(http.StatusServiceUnavailable)
+	defer up1.Close()
+	defer up2.Close()
+	defer down.Close()
+	var tests = []struct {
+		quorum    string
+		succeeded bool
+		statuses  []nestor.Status
+	}{
+		{"", false, []nestor.Status{nestor.StatusSucceeded, nestor.StatusSucceeded, nestor.StatusFailed}},
+		{" quorum all", false, []nestor.Status{nestor.StatusSucceeded, nestor.StatusSucceeded, nestor.StatusFailed}},
+		{` quorum "2"`, true, []nestor.Status{nestor.StatusSucceeded, nestor.StatusSucceeded, nestor.StatusCancelled}},
+		{" quorum any", true, nil},
+	}
+	for _, c := range tests {
+		s := fmt.Sprintf(`poll "%s", "%s", "%s"%s every "50ms" "5" times`, up1.URL, up2.URL, down.URL, c.quorum)
+		res, err := executePoll(t, s)
+		payload := res.Payload.(*nestor.PollerPayload)
+		if payload.Succeeded != c.succeeded {
+			t.Fatalf("expected success=%v for %s. got %v", c.succeeded, s, payload.Succeeded)
+		}
+		if !c.succeeded && err != nestor.ErrorQuorumNotMet {
+			t.Fatalf("expected ErrorQuorumNotMet for %s. got %v", s, err)
+		}
+		if c.succeeded && err != nil {
+			t.Fatalf("expected nil for %s. got %v", s, err)
+		}
+		if len(payload.Endpoints) != 3 {
+			t.Fatalf("expected 3 endpoints. got %d", len(payload.Endpoints))
+		}
+		for i, status := range c.statuses {
+			if payload.Endpoints[i].Status != status {
+				t.Fatalf("expected %v for %s in %s. got %v", status, payload.Endpoints[i].URL, s, payload.Endpoints[i].Status)
+			}
+		}
+	}
+}
+
+func TestPollQuorumAnyFailed(t *testing.T) {
+	down1, down2 := newStatusServer(http.StatusServiceUnavailable), newStatusServer(http.StatusNotFound)
+	defer down1.Close()
+	defer down2.Close()
+	res, err := executePoll(t, fmt.Sprintf(`poll "%s", "%s" quorum any every "10ms" "2" times`, down1.URL, down2.URL))
+	if err != nestor.ErrorQuorumNotMet {
+		t.Fatalf("expected ErrorQuorumNotMet. got %v", err)
+	}
+	for _, e := range res.Payload.(*nestor.PollerPayload).Endpoints {
+		if e.Status != nestor.StatusFailed || e.Error != nestor.ErrorMaxCountExceeded {
+			t.Fatalf("expected FAILED with ErrorMaxCountExceeded for %s. got %v %v", e.URL, e.Status, e.Error)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package nestor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	//RefreshArtifactToken identifies a vault token refresh
+	RefreshArtifactToken string = "TOKEN"
+	//RefreshArtifactCertificate identifies a certificate refresh from a vault pki path
+	RefreshArtifactCertificate string = "CERTIFICATE"
+
+	certificateFileName = "cert.pem"
+	privateKeyFileName  = "key.pem"
+	issuingCAFileName   = "ca.pem"
+)
+
+//RefresherParameters are the parameters of Refresher's Execute
+type RefresherParameters struct {
+	Artifact    string
+	Path        string
+	Destination string
+	Interval    time.Duration
+}
+
+//RefreshResponse is the outcome of a single renewal that is returned through refreshResponseChannel
+type RefreshResponse struct {
+	Artifact string
+	Time     time.Time
+	// Reauthenticated is set when a token could not be renewed and was re-read from its path instead
+	Reauthenticated bool
+	Error           error
+}
+
+//RefresherPayload is the payload of a refresh; the number of renewals which succeeded and failed
+// until the refresh was stopped
+type RefresherPayload struct {
+	Succeeded int
+	Failed    int
+}
+
+//RefreshError is returned by a refresh whose last renewal failed. Refreshes only stop once their
+// context is done, so it marks the statement as failed rather than cancelled.
+type RefreshError struct {
+	Artifact string
+	Err      error
+}
+
+func (e *RefreshError) Error() string {
+	return fmt.Sprintf("refreshing %s: %v", e.Artifact, e.Err)
+}
+
+//Refresher renews vault tokens and re-issues certificates on fixed intervals
+// until it is cancelled. Every renewal outcome is logged and, if a channel is
+// provided, sent through refreshResponseChannel.
+type Refresher struct {
+	vaultService           *VaultService
+	refreshResponseChannel chan<- *RefreshResponse
+}
+
+//RefreshToken renews the vault token of the refresher. If the token is not renewable
+// a new token is read from tokenPath and handed to the vault client once vault accepts it.
+// A token in tokenPath which is the current one, or which vault rejects, is an error.
+func (r *Refresher) RefreshToken(tokenPath string) (reauthenticated bool, err error) {
+	err = r.vaultService.RenewSelfToken()
+	if err != ErrRenewerNotRenewable || tokenPath == "" {
+		return false, err
+	}
+	log.Debugf("Token is not renewable. Re-authenticating from %s", tokenPath)
+	b, err := ioutil.ReadFile(tokenPath)
+	if err != nil {
+		return false, err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return false, fmt.Errorf("no token found in %s", tokenPath)
+	}
+	if token == r.vaultService.client.Token() {
+		return false, fmt.Errorf("token in %s is the token which is not renewable", tokenPath)
+	}
+	if err := r.vaultService.ValidateToken(token); err != nil {
+		return false, fmt.Errorf("invalid token in %s: %v", tokenPath, err)
+	}
+	r.vaultService.SetToken(token)
+	return true, nil
+}
+
+//RefreshCertificate issues a new certificate from a vault pki path and writes it to destination.
+// Issue parameters are passed as query parameters of path, e.g. pki/issue/web?common_name=foo.bar
+func (r *Refresher) RefreshCertificate(path string, destination string) error {
+	if destination == "" {
+		return fmt.Errorf("certificate destination cannot be empty")
+	}
+	issuePath, data, err := splitIssuePath(path)
+	if err != nil {
+		return err
+	}
+	cert, err := r.vaultService.IssueCertificate(issuePath, data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(destination, 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(destination, certificateFileName), cert.Certificate, 0644); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(destination, privateKeyFileName), cert.PrivateKey, 0600); err != nil {
+		return err
+	}
+	if cert.IssuingCA != "" {
+		if err := writeFileAtomic(filepath.Join(destination, issuingCAFileName), cert.IssuingCA, 0644); err != nil {
+			return err
+		}
+	}
+	log.Debugf("Certificate %s saved to %s", cert.SerialNumber, destination)
+	return nil
+}
+
+//Refresh is the blocking implementation of refresh logic. The artifact is refreshed
+// immediately and then on every interval until ctx is cancelled. A RefreshError is returned
+// when the last renewal failed.
+func (r *Refresher) Refresh(ctx context.Context, artifact string, path string, destination string, interval time.Duration) error {
+	return r.refresh(ctx, artifact, path, destination, interval, &RefresherPayload{})
+}
+
+// refresh is Refresh counting the outcomes of the renewals in payload
+func (r *Refresher) refresh(ctx context.Context, artifact string, path string, destination string, interval time.Duration, payload *RefresherPayload) error {
+	if interval <= 0 {
+		return fmt.Errorf("refresh interval must be greater than zero")
+	}
+	var refresh func() (bool, error)
+	switch strings.ToUpper(artifact) {
+	case RefreshArtifactToken:
+		refresh = func() (bool, error) {
+			return r.RefreshToken(path)
+		}
+	case RefreshArtifactCertificate:
+		if destination == "" {
+			return fmt.Errorf("certificate refresh requires a destination")
+		}
+		refresh = func() (bool, error) {
+			return false, r.RefreshCertificate(path, destination)
+		}
+	default:
+		return fmt.Errorf("found %s. expected %s, %s", artifact, RefreshArtifactToken, RefreshArtifactCertificate)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		reauthenticated, err := refresh()
+		if err != nil {
+			payload.Failed++
+		} else {
+			payload.Succeeded++
+		}
+		r.sendRefreshResponse(ctx, &RefreshResponse{
+			Artifact:        strings.ToUpper(artifact),
+			Time:            time.Now(),
+			Reauthenticated: reauthenticated,
+			Error:           err,
+		})
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return &RefreshError{Artifact: strings.ToUpper(artifact), Err: err}
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Refresher) sendRefreshResponse(ctx context.Context, resp *RefreshResponse) {
+	if resp.Error != nil {
+		log.Errorf("Failed refreshing %s: %v", resp.Artifact, resp.Error)
+	} else {
+		log.Infof("Refreshed %s", resp.Artifact)
+	}
+	if r.refreshResponseChannel == nil {
+		return
+	}
+	select {
+	case r.refreshResponseChannel <- resp:
+	case <-ctx.Done():
+	}
+}
+
+//Execute executes Refresher's Refresh with typed parameters and returns the outcomes of the renewals
+func (r *Refresher) Execute(ctx context.Context, params RefresherParameters) (*RefresherPayload, error) {
+	payload := &RefresherPayload{}
+	err := r.refresh(ctx, params.Artifact, params.Path, params.Destination, params.Interval, payload)
+	return payload, err
+}
+
+//NewRefresher is constructor for Refresher class. responseChannel is optional.
+func NewRefresher(vaultService *VaultService, responseChannel chan<- *RefreshResponse) (*Refresher, error) {
+	if vaultService == nil {
+		return nil, fmt.Errorf("vault service cannot be nil")
+	}
+	return &Refresher{
+		vaultService:           vaultService,
+		refreshResponseChannel: responseChannel,
+	}, nil
+}
+
+func splitIssuePath(path string) (string, map[string]interface{}, error) {
+	parts := strings.SplitN(path, "?", 2)
+	data := make(map[string]interface{})
+	if len(parts) == 1 {
+		return parts[0], data, nil
+	}
+	values, err := url.ParseQuery(parts[1])
+	if err != nil {
+		return "", nil, err
+	}
+	for k := range values {
+		data[k] = values.Get(k)
+	}
+	return parts[0], data, nil
+}
+
+func writeFileAtomic(filename string, content string, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
@@ -0,0 +1,214 @@
+package nestor_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	vaultAPI "github.com/hashicorp/vault/api"
+	"github.com/jerminb/nestor"
+	"github.com/jerminb/nestor/lexer"
+	"github.com/jerminb/nestor/testserver"
+)
+
+func createTestToken(t *testing.T, url string, rootToken string, renewable bool) string {
+	cfg := vaultAPI.DefaultConfig()
+	cfg.Address = url
+	c, err := vaultAPI.NewClient(cfg)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	c.SetToken(rootToken)
+	secret, err := c.Auth().Token().Create(&vaultAPI.TokenCreateRequest{
+		Policies:  []string{"allsecrets"},
+		Renewable: &renewable,
+		TTL:       "2m",
+	})
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	return secret.Auth.ClientToken
+}
+
+func runRefresher(t *testing.T, vs *nestor.VaultService, artifact string, path string, destination string) *nestor.RefreshResponse {
+	responseChan := make(chan *nestor.RefreshResponse)
+	r, err := nestor.NewRefresher(vs, responseChan)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- r.Refresh(ctx, artifact, path, destination, time.Hour)
+	}()
+	resp := <-responseChan
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	return resp
+}
+
+func TestRefreshToken(t *testing.T) {
+	testserver.WithTestVaultRootServer(t, func(url string, listner net.Listener, rootToken string) {
+		vs, err := nestor.NewVaultService(url, createTestToken(t, url, rootToken, true))
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		resp := runRefresher(t, vs, "token", "", "")
+		if resp.Error != nil {
+			t.Fatalf("expected nil. got %v", resp.Error)
+		}
+		if resp.Reauthenticated {
+			t.Fatalf("expected renewal. got re-authentication")
+		}
+	})
+}
+
+func TestRefreshTokenReauthenticate(t *testing.T) {
+	testserver.WithTestVaultRootServer(t, func(url string, listner net.Listener, rootToken string) {
+		vs, err := nestor.NewVaultService(url, createTestToken(t, url, rootToken, false))
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		tokenFile := fmt.Sprintf("/tmp/nestor_tests/token_%d", time.Now().UnixNano())
+		defer os.Remove(tokenFile)
+		if err := ioutil.WriteFile(tokenFile, []byte(createTestToken(t, url, rootToken, true)+"\n"), 0600); err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		resp := runRefresher(t, vs, "token", tokenFile, "")
+		if resp.Error != nil {
+			t.Fatalf("expected nil. got %v", resp.Error)
+		}
+		if !resp.Reauthenticated {
+			t.Fatalf("expected re-authentication. got renewal")
+		}
+		if err := vs.RenewSelfToken(); err != nil {
+			t.Fatalf("expected renewable token after re-authentication. got %v", err)
+		}
+	})
+}
+
+func TestRefreshTokenReauthenticateInvalid(t *testing.T) {
+	testserver.WithTestVaultRootServer(t, func(url string, listner net.Listener, rootToken string) {
+		token := createTestToken(t, url, rootToken, false)
+		vs, err := nestor.NewVaultService(url, token)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		r, err := nestor.NewRefresher(vs, nil)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		tokenFile := fmt.Sprintf("/tmp/nestor_tests/token_%d", time.Now().UnixNano())
+		defer os.Remove(tokenFile)
+		for _, content := range []string{token, "not-a-token"} {
+			if err := ioutil.WriteFile(tokenFile, []byte(content+"\n"), 0600); err != nil {
+				t.Fatalf("expected nil. got %v", err)
+			}
+			if reauthenticated, err := r.RefreshToken(tokenFile); err == nil || reauthenticated {
+				t.Fatalf("expected error for %s. got %v %v", content, reauthenticated, err)
+			}
+		}
+		// the client keeps its token
+		if _, err := vs.GetSecretFromPath("secret/client-uuid/sgid/sid/bps-db/password", "value"); err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+	})
+}
+
+func TestRefreshCertificate(t *testing.T) {
+	testserver.WithTestVaultServer(t, func(url string, listner net.Listener, token string) {
+		vs, err := nestor.NewVaultService(url, token)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		destination := fmt.Sprintf("/tmp/nestor_tests/certs_%d", time.Now().UnixNano())
+		defer os.RemoveAll(destination)
+		resp := runRefresher(t, vs, "certificate", "pki/issue/nestor?common_name=foo.nestor.test&ttl=1h", destination)
+		if resp.Error != nil {
+			t.Fatalf("expected nil. got %v", resp.Error)
+		}
+		for _, f := range []string{"cert.pem", "key.pem", "ca.pem"} {
+			b, err := ioutil.ReadFile(filepath.Join(destination, f))
+			if err != nil {
+				t.Fatalf("expected nil. got %v", err)
+			}
+			if !strings.Contains(string(b), "-----BEGIN") {
+				t.Fatalf("expected pem content in %s. got %s", f, string(b))
+			}
+		}
+	})
+}
+
+func TestRefreshNegative(t *testing.T) {
+	testserver.WithTestVaultServer(t, func(url string, listner net.Listener, token string) {
+		vs, err := nestor.NewVaultService(url, token)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		r, err := nestor.NewRefresher(vs, nil)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		if err := r.Refresh(context.Background(), "certificate", "pki/issue/nestor", "", time.Hour); err == nil {
+			t.Error("expected error for missing destination. got nil")
+		}
+		if err := r.Refresh(context.Background(), "password", "secret/foo", "", time.Hour); err == nil {
+			t.Error("expected error for unknown artifact. got nil")
+		}
+	})
+}
+
+func TestExecutionRefreshWithoutVault(t *testing.T) {
+	nestor.SetVaultService(nil)
+	stmt, err := lexer.NewParser(strings.NewReader(`refresh token from "/path/to/file" every "1h"`)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	if _, err := nestor.ExecuteFromStatement(stmt); err != nestor.ErrVaultNotConfigured {
+		t.Fatalf("expected ErrVaultNotConfigured. got %v", err)
+	}
+}
+
+func TestExecutionRefreshOutcome(t *testing.T) {
+	testserver.WithTestVaultServer(t, func(url string, listner net.Listener, token string) {
+		vs, err := nestor.NewVaultService(url, token)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		nestor.SetVaultService(vs)
+		defer nestor.SetVaultService(nil)
+		destination := fmt.Sprintf("/tmp/nestor_tests/certs_%d", time.Now().UnixNano())
+		defer os.RemoveAll(destination)
+
+		for _, tt := range []struct {
+			path   string
+			status nestor.Status
+			failed int
+		}{
+			{"pki/issue/nestor?common_name=foo.nestor.test&ttl=1h", nestor.StatusSucceeded, 0},
+			{"pki/issue/missing?common_name=foo.nestor.test&ttl=1h", nestor.StatusFailed, 1},
+		} {
+			s := fmt.Sprintf(`refresh certificate from "%s" every "1h" save to "%s" timeout "500ms"`, tt.path, destination)
+			stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+			if err != nil {
+				t.Fatalf("expected nil . got %v", err)
+			}
+			res, err := nestor.ExecuteFromStatement(stmt)
+			if res.Status != tt.status {
+				t.Fatalf("expected %s for %s. got %s %v", tt.status, tt.path, res.Status, err)
+			}
+			p, ok := res.Payload.(*nestor.RefresherPayload)
+			if !ok || p.Failed != tt.failed || p.Succeeded != 1-tt.failed {
+				t.Fatalf("expected %d failed renewal for %s. got %v", tt.failed, tt.path, res.Payload)
+			}
+		}
+	})
+}
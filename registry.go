@@ -0,0 +1,50 @@
+package nestor
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/jerminb/nestor/lexer"
+)
+
+//ExecutableFactory builds the Executable of a registered statement.
+// Variables and vault secrets of stmt are resolved before the factory is called.
+type ExecutableFactory func(stmt lexer.Statement) (Executable, error)
+
+var executableRegistry = struct {
+	sync.RWMutex
+	factories map[reflect.Type]ExecutableFactory
+}{
+	factories: make(map[reflect.Type]ExecutableFactory),
+}
+
+//RegisterStatement adds a custom statement to the language without changing nestor.
+// keyword starts the statement and parse reads the rest of it into an AST node of the same type as node,
+// which must embed lexer.ExtensionStatement. factory maps every parsed node to its Executable.
+// Registered statements support variables, secrets, TIMEOUT and & like built-in statements.
+func RegisterStatement(keyword string, node lexer.Statement, parse lexer.ParseFunc, factory ExecutableFactory) error {
+	if node == nil {
+		return fmt.Errorf("statement node of %s cannot be nil", keyword)
+	}
+	if factory == nil {
+		return fmt.Errorf("executable factory of %s cannot be nil", keyword)
+	}
+	typ := reflect.TypeOf(node)
+	executableRegistry.Lock()
+	defer executableRegistry.Unlock()
+	if _, ok := executableRegistry.factories[typ]; ok {
+		return fmt.Errorf("statement %v is already registered", typ)
+	}
+	if err := lexer.RegisterStatement(keyword, parse); err != nil {
+		return err
+	}
+	executableRegistry.factories[typ] = factory
+	return nil
+}
+
+func lookupExecutableFactory(stmt lexer.Statement) ExecutableFactory {
+	executableRegistry.RLock()
+	defer executableRegistry.RUnlock()
+	return executableRegistry.factories[reflect.TypeOf(stmt)]
+}
@@ -0,0 +1,90 @@
+package nestor_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jerminb/nestor"
+	"github.com/jerminb/nestor/lexer"
+)
+
+type announceStatement struct {
+	lexer.ExtensionStatement
+	Message string
+}
+
+func (s *announceStatement) String() string {
+	return "ANNOUNCE " + s.Message + s.BaseStatement.String()
+}
+
+var (
+	registerAnnounce sync.Once
+	announcements    = make(chan string, 10)
+)
+
+func parseAnnounceStatement(p *lexer.Parser) (lexer.Statement, error) {
+	stmt := &announceStatement{}
+	tok, lit := p.ScanIgnoreWhitespace()
+	if tok != lexer.IDENT {
+		return nil, p.NewParseError(lexer.Tokstr(tok, lit), []string{"MESSAGE"})
+	}
+	stmt.Message = lit
+	if err := p.ParseBaseStatement(&stmt.BaseStatement); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func setupAnnounceStatement(t *testing.T) {
+	registerAnnounce.Do(func() {
+		err := nestor.RegisterStatement("announce", &announceStatement{}, parseAnnounceStatement, func(stmt lexer.Statement) (nestor.Executable, error) {
+			message := stmt.(*announceStatement).Message
+			return nestor.ExecutableFunc(func(ctx context.Context) (interface{}, error) {
+				announcements <- message
+				return len(message), nil
+			}), nil
+		})
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+	})
+}
+
+func TestRegisterStatementExecution(t *testing.T) {
+	setupAnnounceStatement(t)
+	q, err := lexer.NewParser(strings.NewReader(`set who = "world"; announce "hello ${who}" timeout "1s" &`)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	results, err := nestor.NewQueryExecutor().ExecuteQuery(q)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results. got %d", len(results))
+	}
+	if results[1].Payload != len("hello world") {
+		t.Fatalf("expected %d. got %v", len("hello world"), results[1].Payload)
+	}
+	if msg := <-announcements; msg != "hello world" {
+		t.Fatalf("expected hello world. got %s", msg)
+	}
+}
+
+func TestRegisterStatementDuplicate(t *testing.T) {
+	setupAnnounceStatement(t)
+	factory := func(stmt lexer.Statement) (nestor.Executable, error) {
+		return nil, nil
+	}
+	if err := nestor.RegisterStatement("announce2", &announceStatement{}, parseAnnounceStatement, factory); err == nil {
+		t.Fatalf("expected error for duplicate node. got nil")
+	}
+	if err := nestor.RegisterStatement("download", &lexer.ExtensionStatement{}, parseAnnounceStatement, factory); err == nil {
+		t.Fatalf("expected error for built-in keyword. got nil")
+	}
+	if err := nestor.RegisterStatement("announce3", nil, parseAnnounceStatement, factory); err == nil {
+		t.Fatalf("expected error for nil node. got nil")
+	}
+}
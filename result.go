@@ -0,0 +1,62 @@
+package nestor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jerminb/nestor/lexer"
+)
+
+//Status is the outcome of an executed statement
+type Status int
+
+const (
+	//StatusSucceeded is the status of a statement that completed without error
+	StatusSucceeded Status = iota
+	//StatusFailed is the status of a statement that returned an error
+	StatusFailed
+	//StatusCancelled is the status of a statement that was stopped by a cancellation or a timeout
+	StatusCancelled
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusSucceeded:
+		return "SUCCEEDED"
+	case StatusFailed:
+		return "FAILED"
+	case StatusCancelled:
+		return "CANCELLED"
+	}
+	return "UNKNOWN"
+}
+
+//Result is the typed outcome of a single statement.
+// Payload holds the executor specific outcome; *PollerPayload, *DownloaderPayload, *DownloadBatchPayload,
+// *DatabaserPayload, *RefresherPayload or, for a query block, []*Result. It is nil for statements
+// without payload.
+type Result struct {
+	Statement lexer.Statement
+	Status    Status
+	Duration  time.Duration
+	Error     error
+	Payload   interface{}
+}
+
+func newResult(stmt lexer.Statement, start time.Time, payload interface{}, err error) *Result {
+	r := &Result{
+		Statement: stmt,
+		Status:    StatusSucceeded,
+		Duration:  time.Since(start),
+		Error:     err,
+		Payload:   payload,
+	}
+	if err != nil {
+		r.Status = StatusFailed
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			r.Status = StatusCancelled
+		}
+	}
+	return r
+}
@@ -0,0 +1,209 @@
+package nestor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// scheduleEveryPrefix starts a fixed interval schedule, e.g. "@every 5m"
+	scheduleEveryPrefix string = "@every "
+	// maxScheduleSearch bounds the search for the next activation of a cron expression
+	maxScheduleSearch = 5 * 366 * 24 * time.Hour
+)
+
+//Schedule returns the activation times of a scheduled statement
+type Schedule interface {
+	// Next returns the first activation time after t, or the zero time if there is none
+	Next(t time.Time) time.Time
+}
+
+//ParseSchedule parses the expression of a SCHEDULE clause. Supported expressions are
+// five field cron expressions (minute, hour, day of month, month, day of week),
+// the descriptors @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly
+// and fixed intervals such as @every 30s.
+func ParseSchedule(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, scheduleEveryPrefix) {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, scheduleEveryPrefix)))
+		if err != nil {
+			return nil, err
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("schedule interval must be greater than zero")
+		}
+		return &intervalSchedule{interval: interval}, nil
+	}
+	switch expr {
+	case "@yearly", "@annually":
+		expr = "0 0 1 1 *"
+	case "@monthly":
+		expr = "0 0 1 * *"
+	case "@weekly":
+		expr = "0 0 * * 0"
+	case "@daily", "@midnight":
+		expr = "0 0 * * *"
+	case "@hourly":
+		expr = "0 * * * *"
+	}
+	return parseCronSchedule(expr)
+}
+
+// intervalSchedule activates on a fixed interval from the time it is asked for
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+func (s *intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// cronField is a bit set of the values allowed in a cron field
+type cronField uint64
+
+func (f cronField) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+type cronBounds struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronMinute     = cronBounds{"minute", 0, 59, nil}
+	cronHour       = cronBounds{"hour", 0, 23, nil}
+	cronDayOfMonth = cronBounds{"day of month", 1, 31, nil}
+	cronMonth      = cronBounds{"month", 1, 12, map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	// day of week 7 is accepted as Sunday and folded into 0
+	cronDayOfWeek = cronBounds{"day of week", 0, 7, map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+)
+
+// cronSchedule activates at the minutes matching a five field cron expression in t's location
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek cronField
+	// a restricted day of month or day of week field matches either day, like cron does
+	dayOfMonthStar, dayOfWeekStar bool
+}
+
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields. got %d", expr, len(fields))
+	}
+	s := &cronSchedule{
+		dayOfMonthStar: fields[2] == "*",
+		dayOfWeekStar:  fields[4] == "*",
+	}
+	var err error
+	for i, f := range []struct {
+		field  *cronField
+		bounds cronBounds
+	}{
+		{&s.minute, cronMinute},
+		{&s.hour, cronHour},
+		{&s.dayOfMonth, cronDayOfMonth},
+		{&s.month, cronMonth},
+		{&s.dayOfWeek, cronDayOfWeek},
+	} {
+		if *f.field, err = parseCronField(fields[i], f.bounds); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", expr, err)
+		}
+	}
+	if s.dayOfWeek.has(7) {
+		s.dayOfWeek |= 1
+	}
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of *, values, ranges and steps like */15 or 1-5/2
+func parseCronField(field string, bounds cronBounds) (cronField, error) {
+	var bits cronField
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field: %s", bounds.name, part)
+			}
+			part = part[:i]
+		}
+		low, high := bounds.min, bounds.max
+		if part != "*" {
+			bound := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = parseCronValue(bound[0], bounds); err != nil {
+				return 0, err
+			}
+			high = low
+			if len(bound) == 2 {
+				if high, err = parseCronValue(bound[1], bounds); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				high = bounds.max
+			}
+			if high < low {
+				return 0, fmt.Errorf("invalid range in %s field: %s", bounds.name, part)
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(s string, bounds cronBounds) (int, error) {
+	if v, ok := bounds.names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < bounds.min || v > bounds.max {
+		return 0, fmt.Errorf("invalid value in %s field: %s. expected %d-%d", bounds.name, s, bounds.min, bounds.max)
+	}
+	return v, nil
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dayOfMonth.has(t.Day())
+	dow := s.dayOfWeek.has(int(t.Weekday()))
+	if s.dayOfMonthStar || s.dayOfWeekStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxScheduleSearch)
+	for t.Before(limit) {
+		if !s.month.has(int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.hour.has(t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !s.minute.has(t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,45 @@
+package nestor_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jerminb/nestor"
+)
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2019, time.December, 31, 22, 17, 30, 0, time.UTC)
+	var tests = []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2019, time.December, 31, 22, 18, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 23 * * *", time.Date(2019, time.December, 31, 23, 30, 0, 0, time.UTC)},
+		{"15,45 9-17/4 * * MON-FRI", time.Date(2020, time.January, 1, 9, 15, 0, 0, time.UTC)},
+		{"0 12 1 FEB *", time.Date(2020, time.February, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 8 13 * 7", time.Date(2020, time.January, 5, 8, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2019, time.December, 31, 23, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2020, time.January, 5, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2019, time.December, 31, 22, 19, 0, 0, time.UTC)},
+	}
+	for _, c := range tests {
+		s, err := nestor.ParseSchedule(c.expr)
+		if err != nil {
+			t.Fatalf("expected nil for %s. got %v", c.expr, err)
+		}
+		if next := s.Next(from); !next.Equal(c.next) {
+			t.Fatalf("expected %v for %s. got %v", c.next, c.expr, next)
+		}
+	}
+}
+
+func TestParseScheduleNegative(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "*/0 * * * *", "5-1 * * * *", "* * * * FOO", "@every", "@every -1s", "@every soon"} {
+		if _, err := nestor.ParseSchedule(expr); err == nil {
+			t.Fatalf("expected error for %s. got nil", expr)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package nestor
+
+import (
+	"context"
+	"time"
+
+	"github.com/jerminb/nestor/lexer"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxMissedRuns bounds the count of activations missed by a single long run
+	maxMissedRuns = 1000
+)
+
+//MissedRunPolicy decides what happens to activations that pass while a scheduled statement is still running.
+// Runs of the same statement never overlap.
+type MissedRunPolicy int
+
+const (
+	//MissedRunSkip drops missed activations and waits for the next one
+	MissedRunSkip MissedRunPolicy = iota
+	//MissedRunOnce runs the statement once right away if any activation was missed
+	MissedRunOnce
+)
+
+//SchedulePayload is the payload of a scheduled statement once its schedule is stopped
+type SchedulePayload struct {
+	Runs     int
+	Failures int
+	// Missed is the count of activations that passed while the statement was running
+	Missed     int
+	LastResult *Result
+}
+
+//Scheduler runs statements with a SCHEDULE clause until their context is cancelled.
+// The result of every run is logged and, if a channel is provided, sent through resultChannel.
+type Scheduler struct {
+	// MissedRunPolicy applies to statements whose SCHEDULE clause has no ON MISSED clause
+	MissedRunPolicy MissedRunPolicy
+	resultChannel   chan<- *Result
+}
+
+var defaultScheduler = NewScheduler(nil)
+
+//SetScheduler sets the Scheduler used for statements with a SCHEDULE clause.
+// A nil scheduler restores the default one.
+func SetScheduler(s *Scheduler) {
+	if s == nil {
+		s = NewScheduler(nil)
+	}
+	defaultScheduler = s
+}
+
+//NewScheduler is constructor for Scheduler class. resultChannel is optional.
+func NewScheduler(resultChannel chan<- *Result) *Scheduler {
+	return &Scheduler{
+		MissedRunPolicy: MissedRunSkip,
+		resultChannel:   resultChannel,
+	}
+}
+
+//getExecuteAt returns when a statement is executed
+func getExecuteAt(stmt lexer.Statement) ExecuteAt {
+	if b, ok := stmt.(baser); ok && b.Base().Schedule != "" {
+		return ExecuteAtScheduled
+	}
+	return ExecuteAtStartUp
+}
+
+// run executes stmt on schedule until ctx is cancelled. With executeAtStartUp the statement
+// also runs once right away. Every run is executed through runStatement.
+func (s *Scheduler) run(ctx context.Context, stmt lexer.Statement, schedule Schedule, executeAtStartUp bool) *SchedulePayload {
+	payload := &SchedulePayload{}
+	policy := s.missedRunPolicy(stmt)
+	runOnce := func() {
+		r := runStatement(ctx, stmt)
+		if ctx.Err() != nil && r.Status == StatusCancelled {
+			// the schedule is stopped; an interrupted run does not count
+			return
+		}
+		payload.Runs++
+		if r.Error != nil {
+			payload.Failures++
+		}
+		payload.LastResult = r
+		s.sendResult(ctx, r)
+	}
+	if executeAtStartUp {
+		runOnce()
+	}
+	now := time.Now()
+	for {
+		next := schedule.Next(now)
+		if next.IsZero() {
+			log.Infof("No activation left for %s", stmt.String())
+			<-ctx.Done()
+			return payload
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return payload
+		case <-timer.C:
+		}
+		for {
+			runOnce()
+			now = time.Now()
+			missed := countActivations(schedule, next, now)
+			if missed == 0 || ctx.Err() != nil {
+				break
+			}
+			payload.Missed += missed
+			log.Warnf("%s missed %d activations while running", stmt.String(), missed)
+			if policy != MissedRunOnce {
+				break
+			}
+			next = now
+		}
+	}
+}
+
+// missedRunPolicy returns the policy chosen by the ON MISSED clause of stmt, or the scheduler's one
+func (s *Scheduler) missedRunPolicy(stmt lexer.Statement) MissedRunPolicy {
+	b, ok := stmt.(baser)
+	if !ok {
+		return s.MissedRunPolicy
+	}
+	switch b.Base().MissedRun {
+	case lexer.SKIP:
+		return MissedRunSkip
+	case lexer.ONCE:
+		return MissedRunOnce
+	}
+	return s.MissedRunPolicy
+}
+
+func (s *Scheduler) sendResult(ctx context.Context, r *Result) {
+	if r.Error != nil {
+		log.Errorf("Scheduled run of %s failed: %v", r.Statement.String(), r.Error)
+	} else {
+		log.Infof("Scheduled run of %s succeeded in %v", r.Statement.String(), r.Duration)
+	}
+	if s.resultChannel == nil {
+		return
+	}
+	select {
+	case s.resultChannel <- r:
+	case <-ctx.Done():
+	}
+}
+
+// countActivations returns the count of activations after from and up to to
+func countActivations(schedule Schedule, from time.Time, to time.Time) int {
+	count := 0
+	for t := schedule.Next(from); !t.IsZero() && !t.After(to) && count < maxMissedRuns; t = schedule.Next(t) {
+		count++
+	}
+	return count
+}
+
+func executeScheduled(ctx context.Context, stmt lexer.Statement) (interface{}, error) {
+	b := stmt.(baser).Base()
+	schedule, err := ParseSchedule(b.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Scheduling %s", stmt.String())
+	return defaultScheduler.run(ctx, stmt, schedule, b.AtStartup), nil
+}
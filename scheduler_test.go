@@ -0,0 +1,110 @@
+package nestor_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jerminb/nestor"
+	"github.com/jerminb/nestor/lexer"
+	"github.com/jerminb/nestor/testserver"
+)
+
+func TestScheduledStatement(t *testing.T) {
+	results := make(chan *nestor.Result, 100)
+	nestor.SetScheduler(nestor.NewScheduler(results))
+	defer nestor.SetScheduler(nil)
+	testserver.WithTestServer(t, func(url string) {
+		s := fmt.Sprintf(`poll "%s" every "1s" "1" times at startup schedule "@every 200ms"; poll "%s" every "1s" "1" times`, url, url)
+		q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+		if err != nil {
+			t.Fatalf("expected nil . got %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*700)
+		defer cancel()
+		start := time.Now()
+		res, err := nestor.NewQueryExecutor().ExecuteQueryWithContext(ctx, q)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < time.Millisecond*700 {
+			t.Fatalf("expected query to run until cancelled. got %v", elapsed)
+		}
+		payload, ok := res[0].Payload.(*nestor.SchedulePayload)
+		if !ok {
+			t.Fatalf("expected *SchedulePayload. got %T", res[0].Payload)
+		}
+		// one run at startup and one every 200ms
+		if payload.Runs < 3 || payload.Runs > 4 {
+			t.Fatalf("expected 3 or 4 runs. got %d", payload.Runs)
+		}
+		if payload.Failures != 0 {
+			t.Fatalf("expected no failure. got %d", payload.Failures)
+		}
+		if len(results) != payload.Runs {
+			t.Fatalf("expected %d results. got %d", payload.Runs, len(results))
+		}
+		if res[1].Payload.(*nestor.PollerPayload).Succeeded != true {
+			t.Fatalf("expected unscheduled statement to run once. got %v", res[1].Payload)
+		}
+	})
+}
+
+func TestScheduledStatementMissedRuns(t *testing.T) {
+	for _, c := range []struct {
+		policy  nestor.MissedRunPolicy
+		clause  string
+		minRuns int
+		maxRuns int
+	}{
+		// a 250ms run every 100ms is followed by a wait for the next activation
+		{nestor.MissedRunSkip, "", 2, 3},
+		// a missed activation runs the statement right after the previous run
+		{nestor.MissedRunOnce, "", 3, 4},
+		// the ON MISSED clause of the statement overrides the policy of the scheduler
+		{nestor.MissedRunSkip, " on missed once", 3, 4},
+		{nestor.MissedRunOnce, " on missed skip", 2, 3},
+	} {
+		scheduler := nestor.NewScheduler(nil)
+		scheduler.MissedRunPolicy = c.policy
+		nestor.SetScheduler(scheduler)
+		testserver.WithTestServer(t, func(url string) {
+			s := fmt.Sprintf(`poll "%s" every "1s" "1" times schedule "@every 100ms"%s`, url, c.clause)
+			stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+			if err != nil {
+				t.Fatalf("expected nil . got %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*1000)
+			defer cancel()
+			res, err := nestor.ExecuteFromStatementWithContext(ctx, stmt)
+			if err != nil {
+				t.Fatalf("expected nil. got %v", err)
+			}
+			payload := res.Payload.(*nestor.SchedulePayload)
+			if payload.Missed == 0 {
+				t.Fatalf("expected missed activations. got 0")
+			}
+			if payload.Runs < c.minRuns || payload.Runs > c.maxRuns {
+				t.Fatalf("expected %d to %d runs with policy %d%s. got %d", c.minRuns, c.maxRuns, c.policy, c.clause, payload.Runs)
+			}
+		},
+			testserver.TimeToFirstByte(time.Millisecond*250))
+	}
+	nestor.SetScheduler(nil)
+}
+
+func TestScheduledStatementInvalidSchedule(t *testing.T) {
+	stmt, err := lexer.NewParser(strings.NewReader(`poll "http://foo.bar" every "1s" "1" times schedule "every day"`)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	res, err := nestor.ExecuteFromStatement(stmt)
+	if err == nil {
+		t.Fatalf("expected error. got nil")
+	}
+	if res.Status != nestor.StatusFailed {
+		t.Fatalf("expected FAILED. got %v", res.Status)
+	}
+}
@@ -0,0 +1,225 @@
+package nestor
+
+import (
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/jerminb/nestor/lexer"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	redactedSecret string = "******"
+)
+
+// secretRegex matches ${vault:path#key} references. The key is optional and defaults to defaultPasswordKey.
+// A leading $ escapes the reference: $${vault:path#key} is kept as ${vault:path#key}.
+var secretRegex = regexp.MustCompile(`\$?\$\{vault:([^}#]+)(?:#([^}]*))?\}`)
+
+//secretResolver replaces vault references with their values through a VaultService.
+// Resolved values are remembered so that they can be redacted from errors.
+type secretResolver struct {
+	vaultService *VaultService
+	secrets      []string
+}
+
+//Resolve replaces every ${vault:path#key} reference in s with the secret read from vault
+// and unescapes the escaped ones
+func (r *secretResolver) Resolve(s string) (string, error) {
+	return r.resolve(s, func(secret string) string { return secret })
+}
+
+// resolveConnectionString is Resolve for connection strings. Secrets are percent-encoded so that
+// their reserved characters, e.g. @, : or /, do not change how the string is parsed, see DBFactory.
+func (r *secretResolver) resolveConnectionString(s string) (string, error) {
+	return r.resolve(s, func(secret string) string {
+		return strings.Replace(neturl.QueryEscape(secret), "+", "%20", -1)
+	})
+}
+
+// resolve replaces the vault references of s with their secrets mapped by encode
+func (r *secretResolver) resolve(s string, encode func(string) string) (string, error) {
+	var err error
+	res := secretRegex.ReplaceAllStringFunc(s, func(m string) string {
+		if strings.HasPrefix(m, "$$") {
+			return m[1:]
+		}
+		if err != nil {
+			return m
+		}
+		if r.vaultService == nil {
+			err = ErrVaultNotConfigured
+			return m
+		}
+		matches := secretRegex.FindStringSubmatch(m)
+		path, keyName := matches[1], matches[2]
+		if keyName == "" {
+			keyName = defaultPasswordKey
+		}
+		val, e := r.vaultService.GetSecretFromPath(path, keyName)
+		if e != nil {
+			err = e
+			return m
+		}
+		secret := fmt.Sprint(val)
+		r.remember(secret)
+		if encoded := encode(secret); encoded != secret {
+			r.remember(encoded)
+			return encoded
+		}
+		return secret
+	})
+	return res, err
+}
+
+// remember keeps secret to redact it from errors, payloads and logs
+func (r *secretResolver) remember(secret string) {
+	r.secrets = append(r.secrets, secret)
+	loggedSecrets.add(secret)
+}
+
+//Redact replaces resolved secrets in err's message
+func (r *secretResolver) Redact(err error) error {
+	if err == nil || len(r.secrets) == 0 {
+		return err
+	}
+	msg := r.RedactString(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return errors.New(msg)
+}
+
+//RedactString replaces resolved secrets in s
+func (r *secretResolver) RedactString(s string) string {
+	for _, secret := range r.secrets {
+		if secret != "" {
+			s = strings.Replace(s, secret, redactedSecret, -1)
+		}
+	}
+	return s
+}
+
+//RedactPayload replaces resolved secrets in the urls, file names and errors of the payloads of
+// polls and downloads, which are built from the resolved statement
+func (r *secretResolver) RedactPayload(payload interface{}) {
+	if len(r.secrets) == 0 {
+		return
+	}
+	switch p := payload.(type) {
+	case *PollerPayload:
+		for _, e := range p.Endpoints {
+			e.URL = r.RedactString(e.URL)
+			e.Error = r.Redact(e.Error)
+		}
+	case *DownloaderPayload:
+		p.URL = r.RedactString(p.URL)
+		p.Filename = r.RedactString(p.Filename)
+	case *DownloadBatchPayload:
+		for _, f := range p.Files {
+			f.URL = r.RedactString(f.URL)
+			f.Filename = r.RedactString(f.Filename)
+			f.Error = r.Redact(f.Error)
+		}
+	}
+}
+
+//resolveSecrets returns a copy of stmt with vault references replaced. The copy must only be used
+// to build executable parameters; the original statement is the one to log or print.
+// The secrets of the connection string of a SQLEXECUTE statement are percent-encoded.
+func (r *secretResolver) resolveSecrets(stmt lexer.Statement) (lexer.Statement, error) {
+	sc := &statementCopier{
+		mapString: r.Resolve,
+	}
+	sqlstmt, ok := stmt.(*lexer.SQLExecuteStatement)
+	if !ok {
+		return sc.copyStatement(stmt)
+	}
+	connectionString, err := r.resolveConnectionString(sqlstmt.DBConnectionString)
+	if err != nil {
+		return nil, fmt.Errorf("%v in statement %s", err, stmt.String())
+	}
+	// the connection string is only resolved once, with its secrets percent-encoded
+	sc.mapString = func(s string) (string, error) {
+		if s == sqlstmt.DBConnectionString {
+			return s, nil
+		}
+		return r.Resolve(s)
+	}
+	resolved, err := sc.copyStatement(stmt)
+	if err != nil {
+		return nil, err
+	}
+	resolved.(*lexer.SQLExecuteStatement).DBConnectionString = connectionString
+	return resolved, nil
+}
+
+// loggedSecrets are the secrets resolved by any statement. They are redacted from every log entry once
+// the first of them is resolved, since executables log the urls and errors of resolved statements.
+var loggedSecrets = &secretSet{values: make(map[string]bool)}
+
+type secretSet struct {
+	mu     sync.RWMutex
+	once   sync.Once
+	values map[string]bool
+}
+
+func (s *secretSet) add(secret string) {
+	if secret == "" {
+		return
+	}
+	s.once.Do(func() {
+		log.AddHook(&redactionHook{secrets: s})
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[secret] = true
+}
+
+func (s *secretSet) redact(str string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for secret := range s.values {
+		str = strings.Replace(str, secret, redactedSecret, -1)
+	}
+	return str
+}
+
+// redactionHook is a logrus hook redacting secrets from the message and fields of log entries
+type redactionHook struct {
+	secrets *secretSet
+}
+
+func (h *redactionHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *redactionHook) Fire(entry *log.Entry) error {
+	entry.Message = h.secrets.redact(entry.Message)
+	if len(entry.Data) == 0 {
+		return nil
+	}
+	// the fields may be shared with the entry the caller derived this one from
+	data := make(log.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		switch val := v.(type) {
+		case string:
+			v = h.secrets.redact(val)
+		case error:
+			v = h.secrets.redact(val.Error())
+		}
+		data[k] = v
+	}
+	entry.Data = data
+	return nil
+}
+
+func newSecretResolver(vaultService *VaultService) *secretResolver {
+	return &secretResolver{
+		vaultService: vaultService,
+	}
+}
@@ -0,0 +1,274 @@
+package nestor_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/jerminb/nestor"
+	"github.com/jerminb/nestor/lexer"
+	"github.com/jerminb/nestor/testserver"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestExecutionSecretReference(t *testing.T) {
+	testserver.WithTestVaultServer(t, func(url string, listner net.Listener, token string) {
+		vs, err := nestor.NewVaultService(url, token)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		nestor.SetVaultService(vs)
+		defer nestor.SetVaultService(nil)
+
+		_, mock, err := sqlmock.NewWithDSN("secrets_test")
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		var password string
+		err = nestor.RegisterSQLDriver("secretsmock", &nestor.SQLDriver{
+			DriverName: "sqlmock",
+			DSN: func(username string, pass string, url string, dbName string, params string) string {
+				password = pass
+				return dbName
+			},
+		})
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		mock.ExpectExec("INSERT INTO eda_sp_permission;").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO eda_sp_perm;").WillReturnResult(sqlmock.NewResult(2, 2))
+
+		s := `sqlexecute from "assets/databaser_test_regex.sql" into "secretsmock://user:${vault:secret/client-uuid/sgid/sid/bps-db/password#value}@localhost/secrets_test"`
+		stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil . got %v", err)
+		}
+		if _, err := nestor.ExecuteFromStatement(stmt); err != nil {
+			t.Fatalf("expected no error. got %v", err)
+		}
+		if password != "averysecretpassword" {
+			t.Fatalf("expected averysecretpassword. got %s", password)
+		}
+		if strings.Contains(stmt.String(), "averysecretpassword") {
+			t.Fatalf("expected secret to be kept out of the statement. got %s", stmt.String())
+		}
+	})
+}
+
+func TestExecutionSecretConnectionString(t *testing.T) {
+	testserver.WithTestVaultServer(t, func(url string, listner net.Listener, token string) {
+		vs, err := nestor.NewVaultService(url, token)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		nestor.SetVaultService(vs)
+		defer nestor.SetVaultService(nil)
+
+		_, mock, err := sqlmock.NewWithDSN("secrets_reserved_test")
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		var properties []string
+		err = nestor.RegisterSQLDriver("reservedmock", &nestor.SQLDriver{
+			DriverName: "sqlmock",
+			DSN: func(username string, pass string, url string, dbName string, params string) string {
+				properties = []string{username, pass, url, dbName}
+				return dbName
+			},
+		})
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		mock.ExpectExec("INSERT INTO eda_sp_permission;").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO eda_sp_perm;").WillReturnResult(sqlmock.NewResult(2, 2))
+
+		s := `sqlexecute from "assets/databaser_test_regex.sql" into "reservedmock://user:${vault:secret/client-uuid/sgid/sid/bps-db/reserved#value}@localhost/secrets_reserved_test"`
+		stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil . got %v", err)
+		}
+		if _, err := nestor.ExecuteFromStatement(stmt); err != nil {
+			t.Fatalf("expected no error. got %v", err)
+		}
+		expected := []string{"user", "p@ss:w/rd?#% +", "localhost", "secrets_reserved_test"}
+		if strings.Join(properties, "|") != strings.Join(expected, "|") {
+			t.Fatalf("expected %v. got %v", expected, properties)
+		}
+	})
+}
+
+func TestExecutionSecretRedacted(t *testing.T) {
+	testserver.WithTestVaultServer(t, func(url string, listner net.Listener, token string) {
+		vs, err := nestor.NewVaultService(url, token)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		nestor.SetVaultService(vs)
+		defer nestor.SetVaultService(nil)
+
+		s := `poll "http://foo.bar" every "${vault:secret/client-uuid/sgid/sid/bps-db/password}" "1" times`
+		stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil . got %v", err)
+		}
+		_, err = nestor.ExecuteFromStatement(stmt)
+		if err == nil {
+			t.Fatalf("expected error. got nil")
+		}
+		if strings.Contains(err.Error(), "averysecretpassword") {
+			t.Fatalf("expected secret to be redacted. got %v", err)
+		}
+	})
+}
+
+func TestExecutionSecretWithoutVault(t *testing.T) {
+	nestor.SetVaultService(nil)
+	s := `download from "http://foo.bar" save to "${vault:secret/foo#bar}"`
+	stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	if _, err := nestor.ExecuteFromStatement(stmt); err == nil || !strings.Contains(err.Error(), nestor.ErrVaultNotConfigured.Error()) {
+		t.Fatalf("expected ErrVaultNotConfigured. got %v", err)
+	}
+}
+
+func TestExecutionSecretEscaped(t *testing.T) {
+	nestor.SetVaultService(nil)
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	s := newContentServer("content", 0)
+	defer s.Close()
+	q, err := lexer.NewParser(strings.NewReader(`download from "` + s.URL + `" save to "` + dir + `/$${vault:secret/foo#bar}"`)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	if _, err := nestor.NewQueryExecutor().ExecuteQuery(q); err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	checkContent(t, dir+"/${vault:secret/foo#bar}", "content")
+}
+
+func TestExecutionSecretBearerToken(t *testing.T) {
+	testserver.WithTestVaultServer(t, func(url string, listner net.Listener, token string) {
+		vs, err := nestor.NewVaultService(url, token)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		nestor.SetVaultService(vs)
+		defer nestor.SetVaultService(nil)
+
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer averysecretpassword" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}))
+		defer s.Close()
+		stmt, err := lexer.NewParser(strings.NewReader(`poll "` + s.URL + `" auth bearer "${vault:secret/client-uuid/sgid/sid/bps-db/password#value}" every "100ms" "1" times`)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil . got %v", err)
+		}
+		res, err := nestor.ExecuteFromStatement(stmt)
+		if err != nil {
+			t.Fatalf("expected no error. got %v", err)
+		}
+		if !res.Payload.(*nestor.PollerPayload).Succeeded {
+			t.Fatalf("expected success=true. got false")
+		}
+	})
+}
+
+func TestExecutionSecretDownloadBasicAuth(t *testing.T) {
+	testserver.WithTestVaultServer(t, func(url string, listner net.Listener, token string) {
+		vs, err := nestor.NewVaultService(url, token)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		nestor.SetVaultService(vs)
+		defer nestor.SetVaultService(nil)
+
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "deployer" || pass != "averysecretpassword" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("artifact"))
+		}))
+		defer s.Close()
+		filename := fmt.Sprintf("/tmp/nestor_tests/%d", time.Now().UnixNano())
+		defer os.Remove(filename)
+		stmt, err := lexer.NewParser(strings.NewReader(`download from "` + s.URL + `/artifact" save to "` + filename + `" with auth basic "deployer" "${vault:secret/client-uuid/sgid/sid/bps-db/password#value}"`)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil . got %v", err)
+		}
+		if _, err := nestor.ExecuteFromStatement(stmt); err != nil {
+			t.Fatalf("expected no error. got %v", err)
+		}
+		if b, err := ioutil.ReadFile(filename); err != nil || string(b) != "artifact" {
+			t.Fatalf("expected artifact in %s. got %s %v", filename, b, err)
+		}
+	})
+}
+
+func TestExecutionSecretURLRedacted(t *testing.T) {
+	testserver.WithTestVaultServer(t, func(url string, listner net.Listener, token string) {
+		vs, err := nestor.NewVaultService(url, token)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		nestor.SetVaultService(vs)
+		defer nestor.SetVaultService(nil)
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		defer log.SetOutput(os.Stderr)
+
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer failing.Close()
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("artifact"))
+		}))
+		defer s.Close()
+		secret := "?token=${vault:secret/client-uuid/sgid/sid/bps-db/password#value}"
+		dir := newTestDir(t)
+		defer os.RemoveAll(dir)
+
+		res, err := executePoll(t, fmt.Sprintf(`poll "%s/%s", "%s/%s" quorum any every "10ms" "1" times`, failing.URL, secret, s.URL, secret))
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		for _, e := range res.Payload.(*nestor.PollerPayload).Endpoints {
+			if strings.Contains(e.URL, "averysecretpassword") || !strings.Contains(e.URL, "******") {
+				t.Fatalf("expected secret to be redacted. got %s", e.URL)
+			}
+		}
+		res, err = executePoll(t, fmt.Sprintf(`download from "%s/f%s" or "%s/f%s" save to "%s/f"`, failing.URL, secret, s.URL, secret, dir))
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		if p := res.Payload.(*nestor.DownloaderPayload); strings.Contains(p.URL, "averysecretpassword") {
+			t.Fatalf("expected secret to be redacted. got %s", p.URL)
+		}
+		res, err = executePoll(t, fmt.Sprintf(`download all from ("%s/a%s", "%s/b%s") save to "%s" on error continue`, failing.URL, secret, s.URL, secret, dir))
+		for _, f := range res.Payload.(*nestor.DownloadBatchPayload).Files {
+			if strings.Contains(f.URL, "averysecretpassword") || (f.Error != nil && strings.Contains(f.Error.Error(), "averysecretpassword")) {
+				t.Fatalf("expected secret to be redacted. got %s %v", f.URL, f.Error)
+			}
+		}
+		if !strings.Contains(logs.String(), "failed") {
+			t.Fatalf("expected failures to be logged. got %s", logs.String())
+		}
+		if strings.Contains(logs.String(), "averysecretpassword") {
+			t.Fatalf("expected secret to be redacted from logs. got %s", logs.String())
+		}
+	})
+}
@@ -6,11 +6,15 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	vaultAPI "github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/builtin/logical/pki"
 	vaultHttp "github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/hashicorp/vault/vault"
 )
 
@@ -31,7 +35,9 @@ type handler struct {
 	acceptRanges          bool
 	ttfb                  time.Duration
 	lastModified          time.Time
+	etag                  string
 	rateLimiter           *time.Ticker
+	mu                    sync.Mutex
 	currentRequestCount   int
 	maxErrorCount         int
 	httpErrorResponseCode int
@@ -94,12 +100,9 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		httpError(w, http.StatusMethodNotAllowed)
 		return
 	}
-	if h.maxErrorCount > -1 {
-		if h.currentRequestCount < h.maxErrorCount {
-			h.currentRequestCount++
-			httpError(w, h.httpErrorResponseCode)
-			return
-		}
+	if h.maxErrorCount > -1 && h.countError() {
+		httpError(w, h.httpErrorResponseCode)
+		return
 	}
 
 	// set last modified timestamp
@@ -108,6 +111,13 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		lastMod = h.lastModified
 	}
 	w.Header().Set("Last-Modified", lastMod.Format(http.TimeFormat))
+	if h.etag != "" {
+		w.Header().Set("ETag", h.etag)
+	}
+	if h.isNotModified(r) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
 	// set content-length
 	offset := 0
@@ -144,6 +154,35 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// isNotModified evaluates the conditional headers of r against the configured ETag and Last-Modified.
+// If-None-Match takes precedence over If-Modified-Since.
+func (h *handler) isNotModified(r *http.Request) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if h.etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(match, ",") {
+			if tag = strings.TrimSpace(tag); tag == h.etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !h.lastModified.IsZero() && !h.lastModified.After(since)
+}
+
+// countError reports whether the current request is one of the first maxErrorCount requests
+func (h *handler) countError() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.currentRequestCount < h.maxErrorCount {
+		h.currentRequestCount++
+		return true
+	}
+	return false
+}
+
 // isRequestClosed returns true if the client request has been canceled.
 func isRequestClosed(r *http.Request) bool {
 	ctx := r.Context()
@@ -162,8 +201,40 @@ func httpError(w http.ResponseWriter, code int) {
 //WithTestVaultServer sets up a test vault server with a vault token with secrets/* path access
 func WithTestVaultServer(t *testing.T, f func(url string, listner net.Listener, token string)) {
 	t.Helper()
+	withTestVaultCore(t, func(c *vaultAPI.Client, addr string, listner net.Listener) {
+		// This is just ridiculous. Renewable is a *bool and there is no easy way to pass
+		// a boolean pointer. https://stackoverflow.com/questions/32364027/reference-a-boolean-for-assignment-in-a-struct
+		trueBool := true
+		tokenCreateOpts := &vaultAPI.TokenCreateRequest{
+			Policies:  []string{"allsecrets"},
+			Renewable: &trueBool,
+			TTL:       "2m",
+		}
+		customToken, err := c.Auth().Token().Create(tokenCreateOpts)
+		if err != nil {
+			t.Fatalf("Error creating custom token: %v", err)
+		}
+		f(addr, listner, customToken.Auth.ClientToken)
+	})
+}
+
+//WithTestVaultRootServer sets up a test vault server and hands the root token to f so tests can create
+// tokens with custom properties. The server has the same secrets and pki mounts as WithTestVaultServer.
+func WithTestVaultRootServer(t *testing.T, f func(url string, listner net.Listener, rootToken string)) {
+	t.Helper()
+	withTestVaultCore(t, func(c *vaultAPI.Client, addr string, listner net.Listener) {
+		f(addr, listner, c.Token())
+	})
+}
+
+func withTestVaultCore(t *testing.T, f func(c *vaultAPI.Client, addr string, listner net.Listener)) {
+	t.Helper()
 
-	core, keys, rootToken := vault.TestCoreUnsealed(t)
+	core, keys, rootToken := vault.TestCoreUnsealedWithConfig(t, &vault.CoreConfig{
+		LogicalBackends: map[string]logical.Factory{
+			"pki": pki.Factory,
+		},
+	})
 
 	for _, key := range keys {
 		if _, err := core.Unseal(key); err != nil {
@@ -185,9 +256,12 @@ func WithTestVaultServer(t *testing.T, f func(url string, listner net.Listener,
 		t.Fatalf("Error creating client in mock vault setup: %v\n", err)
 	}
 	c.SetToken(rootToken)
-	// Set policy to allow use of anything /secrets/*
+	// Set policy to allow use of anything /secrets/* and certificate issuance from pki
 	rules := `path "secret/*" {
 		capabilities = ["create", "read", "update", "delete", "list"]
+  }
+  path "pki/issue/*" {
+		capabilities = ["create", "update"]
   }`
 	err = c.Sys().PutPolicy("allsecrets", rules)
 	if err != nil {
@@ -200,17 +274,37 @@ func WithTestVaultServer(t *testing.T, f func(url string, listner net.Listener,
 	if err != nil {
 		t.Fatalf("Error setting up secret: %v", err)
 	}
-	// This is just ridiculous. Renewable is a *bool and there is no easy way to pass
-	// a boolean pointer. https://stackoverflow.com/questions/32364027/reference-a-boolean-for-assignment-in-a-struct
-	trueBool := true
-	tokenCreateOpts := &vaultAPI.TokenCreateRequest{
-		Policies:  []string{"allsecrets"},
-		Renewable: &trueBool,
-		TTL:       "2m",
+	_, err = c.Logical().Write("secret/client-uuid/sgid/sid/bps-db/reserved", map[string]interface{}{
+		"value":  "p@ss:w/rd?#% +",
+		"number": 42,
+	})
+	if err != nil {
+		t.Fatalf("Error setting up secret: %v", err)
+	}
+
+	// Mount a pki backend with a self-signed root and a role that can issue certificates for any name
+	err = c.Sys().Mount("pki", &vaultAPI.MountInput{
+		Type: "pki",
+		Config: vaultAPI.MountConfigInput{
+			MaxLeaseTTL: "87600h",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error mounting pki: %v", err)
 	}
-	customToken, err := c.Auth().Token().Create(tokenCreateOpts)
+	_, err = c.Logical().Write("pki/root/generate/internal", map[string]interface{}{
+		"common_name": "nestor.test",
+		"ttl":         "87600h",
+	})
+	if err != nil {
+		t.Fatalf("Error generating pki root: %v", err)
+	}
+	_, err = c.Logical().Write("pki/roles/nestor", map[string]interface{}{
+		"allow_any_name": true,
+		"max_ttl":        "72h",
+	})
 	if err != nil {
-		t.Fatalf("Error creating custom token: %v", err)
+		t.Fatalf("Error creating pki role: %v", err)
 	}
-	f(addr, listner, customToken.Auth.ClientToken)
+	f(c, addr, listner)
 }
@@ -80,3 +80,27 @@ func MaxErrorCount(maxErrorCount int) HandlerOption {
 		return nil
 	}
 }
+
+//LastModified sets the Last-Modified time returned by handler. Requests with an If-Modified-Since
+// header not older than t are answered with 304 Not Modified.
+func LastModified(t time.Time) HandlerOption {
+	return func(h *handler) error {
+		if t.IsZero() {
+			return errors.New("last modified time cannot be zero")
+		}
+		h.lastModified = t.UTC().Truncate(time.Second)
+		return nil
+	}
+}
+
+//ETag sets the entity tag returned by handler. Requests with an If-None-Match header matching tag are
+// answered with 304 Not Modified.
+func ETag(tag string) HandlerOption {
+	return func(h *handler) error {
+		if tag == "" {
+			return errors.New("entity tag cannot be empty")
+		}
+		h.etag = tag
+		return nil
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/jerminb/nestor/testserver"
 )
@@ -106,3 +107,38 @@ func TestHandlerContentLength(t *testing.T) {
 		)
 	}
 }
+
+func TestHandlerConditional(t *testing.T) {
+	lastModified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		Header           string
+		Value            string
+		ExpectStatusCode int
+	}{
+		{"If-None-Match", `"v1"`, http.StatusNotModified},
+		{"If-None-Match", `"v0", "v1"`, http.StatusNotModified},
+		{"If-None-Match", `"v2"`, http.StatusOK},
+		{"If-Modified-Since", lastModified.Format(http.TimeFormat), http.StatusNotModified},
+		{"If-Modified-Since", lastModified.Add(-time.Second).Format(http.TimeFormat), http.StatusOK},
+	}
+	testserver.WithTestServer(t, func(url string) {
+		for _, test := range tests {
+			req, err := http.NewRequest("GET", url, nil)
+			if err != nil {
+				t.Fatalf("expected nil. got %v", err)
+			}
+			req.Header.Set(test.Header, test.Value)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("expected nil. got %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.ExpectStatusCode {
+				t.Fatalf("expected %d for %s: %s. got %d", test.ExpectStatusCode, test.Header, test.Value, resp.StatusCode)
+			}
+			if resp.Header.Get("ETag") != `"v1"` {
+				t.Fatalf("expected ETag \"v1\". got %s", resp.Header.Get("ETag"))
+			}
+		}
+	}, testserver.LastModified(lastModified), testserver.ETag(`"v1"`))
+}
@@ -0,0 +1,276 @@
+package nestor
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jerminb/nestor/lexer"
+)
+
+// httpTokenRegex matches valid HTTP methods and header names
+var httpTokenRegex = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+//ValidationErrors is the list of semantic errors found in a statement
+type ValidationErrors []error
+
+// Error returns the string representation of all errors, one per line.
+func (e ValidationErrors) Error() string {
+	var str []string
+	for _, err := range e {
+		str = append(str, err.Error())
+	}
+	return strings.Join(str, "\n")
+}
+
+//Validate checks the semantics of a parsed statement without executing it; durations, schedules,
+// retry counts, backoffs, URLs, request methods and headers, poll expectations, download checksums,
+// extraction directories, download manifests, refresh artifacts and sql schemes.
+// Literals with ${...} references are resolved at execution time and are therefore not checked.
+// All errors are returned together as ValidationErrors.
+func Validate(stmt lexer.Statement) error {
+	v := &validator{}
+	v.validate(stmt)
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) validate(stmt lexer.Statement) {
+	if b, ok := stmt.(baser); ok {
+		v.checkDuration(stmt, "timeout", b.Base().Timeout, true)
+		if schedule := b.Base().Schedule; schedule != "" && !hasReference(schedule) {
+			if _, err := ParseSchedule(schedule); err != nil {
+				v.addError(stmt, err)
+			}
+		}
+	}
+	switch s := stmt.(type) {
+	case *lexer.Query:
+		for _, child := range s.Statements {
+			v.validate(child)
+		}
+	case *lexer.PollStatement:
+		for _, u := range s.Endpoints() {
+			v.checkProbeURL(s, u)
+		}
+		if !hasReference(s.Quorum) {
+			if _, err := getPollerQuorum(s); err != nil {
+				v.addError(stmt, fmt.Errorf("invalid quorum: %v", err))
+			}
+		}
+		v.checkDuration(stmt, "interval", s.Interval, false)
+		v.checkDuration(stmt, "initial wait time", s.InitialWaitTime, true)
+		v.checkPositiveInt(stmt, "retry count", s.MaxRetryCount)
+		v.checkDuration(stmt, "deadline", s.Deadline, true)
+		if s.DownCount != "" {
+			v.checkPositiveInt(stmt, "down count", s.DownCount)
+		}
+		if s.Backoff != nil {
+			v.checkDuration(stmt, "max backoff", s.Backoff.Max, true)
+		}
+		v.checkRequestOptions(stmt, s.Request)
+		for _, e := range s.Expectations {
+			if hasReference(e.Path) || hasReference(e.Value) {
+				continue
+			}
+			if _, err := NewExpectation(e.Subject.String(), e.Path, e.Operator.String(), e.Value); err != nil {
+				v.addError(stmt, err)
+			}
+		}
+	case *lexer.DownloadStatement:
+		if s.All {
+			for _, url := range s.URLs {
+				v.checkURL(stmt, url)
+			}
+			if len(s.URLs) == 0 {
+				v.checkNotEmpty(stmt, "manifest", s.Manifest)
+			}
+			v.checkNotEmpty(stmt, "directory", s.FilePath)
+			v.checkRequestOptions(stmt, s.Request)
+			break
+		}
+		for _, url := range s.Sources() {
+			v.checkURL(stmt, url)
+		}
+		v.checkNotEmpty(stmt, "file path", s.FilePath)
+		v.checkRequestOptions(stmt, s.Request)
+		v.checkVerifications(stmt, s.Verifications)
+		if s.Extract != nil {
+			v.checkNotEmpty(stmt, "extraction directory", s.Extract.Directory)
+			if strip := s.Extract.StripComponents; strip != "" && !hasReference(strip) {
+				if n, err := strconv.Atoi(strip); err != nil {
+					v.addError(stmt, fmt.Errorf("invalid strip components: %v", err))
+				} else if n < 0 {
+					v.addError(stmt, fmt.Errorf("strip components cannot be negative"))
+				}
+			}
+		}
+	case *lexer.SQLExecuteStatement:
+		v.checkNotEmpty(stmt, "file path", s.FilePath)
+		if !hasReference(s.DBConnectionString) {
+			if _, _, err := getSQLDriverFromConnectionString(s.DBConnectionString); err != nil {
+				v.addError(stmt, err)
+			}
+		}
+	case *lexer.RefreshStatement:
+		switch strings.ToUpper(s.Artifact) {
+		case RefreshArtifactToken:
+		case RefreshArtifactCertificate:
+			v.checkNotEmpty(stmt, "certificate destination", s.Destination)
+		default:
+			v.addError(stmt, fmt.Errorf("found %s. expected %s, %s", s.Artifact, RefreshArtifactToken, RefreshArtifactCertificate))
+		}
+		v.checkDuration(stmt, "interval", s.Interval, false)
+	case *lexer.SetStatement:
+		v.checkNotEmpty(stmt, "variable name", s.Name)
+	}
+}
+
+func (v *validator) addError(stmt lexer.Statement, err error) {
+	v.errs = append(v.errs, fmt.Errorf("%v in statement %s", err, stmt.String()))
+}
+
+func (v *validator) checkDuration(stmt lexer.Statement, name string, value string, optional bool) {
+	if hasReference(value) || (optional && value == "") {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		v.addError(stmt, fmt.Errorf("invalid %s: %v", name, err))
+		return
+	}
+	if d <= 0 {
+		v.addError(stmt, fmt.Errorf("%s must be greater than zero", name))
+	}
+}
+
+func (v *validator) checkPositiveInt(stmt lexer.Statement, name string, value string) {
+	if hasReference(value) {
+		return
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		v.addError(stmt, fmt.Errorf("invalid %s: %v", name, err))
+		return
+	}
+	if n <= 0 {
+		v.addError(stmt, fmt.Errorf("%s must be greater than zero", name))
+	}
+}
+
+func (v *validator) checkURL(stmt lexer.Statement, value string) {
+	if hasReference(value) {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		v.addError(stmt, err)
+		return
+	}
+	if u.Scheme == "" || u.Host == "" {
+		v.addError(stmt, fmt.Errorf("invalid url %s: expected scheme://host", value))
+	}
+}
+
+func (v *validator) checkRequestOptions(stmt lexer.Statement, opts lexer.RequestOptions) {
+	if opts.Method != "" && !hasReference(opts.Method) && !httpTokenRegex.MatchString(opts.Method) {
+		v.addError(stmt, fmt.Errorf("invalid method %s", opts.Method))
+	}
+	for _, h := range opts.Headers {
+		if !hasReference(h.Name) && !httpTokenRegex.MatchString(h.Name) {
+			v.addError(stmt, fmt.Errorf("invalid header name %q", h.Name))
+		}
+	}
+}
+
+// checkVerifications checks that a download has at most one checksum and one signature and
+// that literal checksums are hex digests of the algorithm's size
+func (v *validator) checkVerifications(stmt lexer.Statement, verifications []*lexer.Verification) {
+	checksums, signatures := 0, 0
+	for _, verification := range verifications {
+		if verification.Algorithm == lexer.SIGNATURE {
+			signatures++
+		} else {
+			checksums++
+		}
+		if verification.From != "" {
+			v.checkURL(stmt, verification.From)
+			continue
+		}
+		if hasReference(verification.Checksum) {
+			continue
+		}
+		h, err := newChecksumHash(verification.Algorithm.String())
+		if err != nil {
+			v.addError(stmt, err)
+			continue
+		}
+		sum, err := parseChecksum(verification.Checksum)
+		if err != nil {
+			v.addError(stmt, err)
+			continue
+		}
+		if len(sum) != h.Size() {
+			v.addError(stmt, fmt.Errorf("invalid %s checksum size %d. expected %d", verification.Algorithm, len(sum), h.Size()))
+		}
+	}
+	if checksums > 1 || signatures > 1 {
+		v.addError(stmt, fmt.Errorf("expected a single checksum and a single signature verification"))
+	}
+}
+
+// checkProbeURL checks the url of a poll statement. tcp and dns probes neither send requests
+// nor evaluate expectations.
+func (v *validator) checkProbeURL(s *lexer.PollStatement, rawURL string) {
+	if hasReference(rawURL) {
+		return
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		v.addError(s, err)
+		return
+	}
+	switch u.Scheme {
+	case ProbeSchemeTCP:
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			v.addError(s, fmt.Errorf("invalid url %s: expected tcp://host:port", rawURL))
+		}
+	case ProbeSchemeDNS:
+		if u.Hostname() == "" {
+			v.addError(s, fmt.Errorf("invalid url %s: expected dns://name", rawURL))
+		}
+	case ProbeSchemeUnix:
+		if _, _, err := parseUnixProbeURL(u); err != nil {
+			v.addError(s, err)
+		}
+		return
+	default:
+		v.checkURL(s, rawURL)
+		return
+	}
+	if len(s.Expectations) > 0 || !reflect.DeepEqual(s.Request, lexer.RequestOptions{}) {
+		v.addError(s, fmt.Errorf("%s probes do not support request options or expectations", u.Scheme))
+	}
+}
+
+func (v *validator) checkNotEmpty(stmt lexer.Statement, name string, value string) {
+	if value == "" {
+		v.addError(stmt, fmt.Errorf("%s cannot be empty", name))
+	}
+}
+
+// hasReference reports whether s contains a ${...} reference that is only resolved at execution time
+func hasReference(s string) bool {
+	return variableRegex.MatchString(s)
+}
@@ -0,0 +1,118 @@
+package nestor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jerminb/nestor"
+	"github.com/jerminb/nestor/lexer"
+)
+
+func TestValidate(t *testing.T) {
+	s := `set url = "http://foo.bar"; poll "${url}" every "2s" "10" times timeout "1m"; (download from "http://foo.bar" save to "/tmp/foo" &) &`
+	q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	if err := nestor.Validate(q); err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	s := `poll "http://foo.bar" every "2" "0" times timeout "soon"; (refresh certificate from "pki/issue/nestor" every "-1s")`
+	q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	err = nestor.Validate(q)
+	errs, ok := err.(nestor.ValidationErrors)
+	if !ok {
+		t.Fatalf("expected ValidationErrors. got %v", err)
+	}
+	if len(errs) != 5 {
+		t.Fatalf("expected 5 errors. got %d: %v", len(errs), errs)
+	}
+	if !strings.Contains(errs[0].Error(), "invalid timeout") || !strings.Contains(errs[0].Error(), "POLL") {
+		t.Fatalf("expected timeout error naming the statement. got %v", errs[0])
+	}
+}
+
+func TestValidateExpectations(t *testing.T) {
+	s := `poll "http://foo.bar" every "2s" "10" times expect body =~ "(up" expect json "$.a[x]" = "UP" expect body =~ "${pattern}"`
+	stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	errs, ok := nestor.Validate(stmt).(nestor.ValidationErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected 2 errors. got %v", errs)
+	}
+}
+
+func TestValidateRequestOptions(t *testing.T) {
+	s := `poll "http://foo.bar" method "GET POST" header "X Probe" "nestor" header "${name}" "nestor" every "2s" "10" times`
+	stmt, err := lexer.NewParser(strings.NewReader(s)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	errs, ok := nestor.Validate(stmt).(nestor.ValidationErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected 2 errors. got %v", errs)
+	}
+}
+
+func TestValidateQuorum(t *testing.T) {
+	s := `poll "http://a", "http://b" quorum "3" every "2s" "10" times; poll "http://a", "b" quorum "none" every "2s" "10" times`
+	q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	errs, ok := nestor.Validate(q).(nestor.ValidationErrors)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("expected 3 errors. got %v", errs)
+	}
+}
+
+func TestValidateUntilDown(t *testing.T) {
+	stmt, err := lexer.NewParser(strings.NewReader(`poll "http://a" every "2s" "10" times until down "0" times`)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	if err := nestor.Validate(stmt); err == nil || !strings.Contains(err.Error(), "down count") {
+		t.Fatalf("expected down count error. got %v", err)
+	}
+}
+
+func TestValidateDownloadVerify(t *testing.T) {
+	for _, c := range []struct {
+		query string
+		err   string
+	}{
+		{`download from "http://a/f" save to "/tmp/f" verify sha256 "abcd"`, "checksum size"},
+		{`download from "http://a/f" save to "/tmp/f" with header "X Repo" "libs"`, "invalid header name"},
+		{`download from "http://a/f" save to "/tmp/f" extract to "/opt/app" strip "-1"`, "strip components"},
+		{`download from "http://a/f" save to "/tmp/f" extract to "" strip "1"`, "extraction directory"},
+		{`download from "http://a/f" save to "/tmp/f" verify sha512 "xyz"`, "invalid checksum"},
+		{`download from "http://a/f" save to "/tmp/f" verify sha256 from "f.sha256"`, "invalid url"},
+		{`download from "http://a/f" save to "/tmp/f" verify sha256 from "http://a/f.sha256" verify sha512 from "http://a/f.sha512"`, "single checksum"},
+		{`download from "http://a/f" or "mirror/f" save to "/tmp/f"`, "invalid url"},
+		{`download all from ("mirror/f") save to "/tmp"`, "invalid url"},
+		{`download all from manifest "" save to "/tmp"`, "manifest cannot be empty"},
+	} {
+		stmt, err := lexer.NewParser(strings.NewReader(c.query)).ParseStatement()
+		if err != nil {
+			t.Fatalf("expected nil . got %v", err)
+		}
+		if err := nestor.Validate(stmt); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("expected %s error for %s. got %v", c.err, c.query, err)
+		}
+	}
+	stmt, err := lexer.NewParser(strings.NewReader(`download from "http://a/f" save to "/tmp/f" verify sha256 "${sum}" verify signature from "http://a/f.sig"`)).ParseStatement()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	if err := nestor.Validate(stmt); err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package nestor
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/jerminb/nestor/lexer"
+)
+
+// variableRegex matches ${name} references. A leading $ escapes the reference: $${name} is kept as ${name}.
+// Escaped vault references are only unescaped once secrets are resolved, see secretResolver.Resolve.
+var variableRegex = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+//Variables holds the values available to ${name} interpolation in statement literals
+type Variables map[string]string
+
+//NewVariablesFromEnvironment returns the variables of the process environment
+func NewVariablesFromEnvironment() Variables {
+	vars := make(Variables)
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			vars[parts[0]] = parts[1]
+		}
+	}
+	return vars
+}
+
+//Interpolate replaces ${name} references in s with their values.
+// References with a scheme such as ${vault:path#key} are kept as they are resolved at execution time.
+func (v Variables) Interpolate(s string) (string, error) {
+	var err error
+	res := variableRegex.ReplaceAllStringFunc(s, func(m string) string {
+		if strings.HasPrefix(m, "$$") {
+			if secretRegex.MatchString(m[1:]) {
+				return m
+			}
+			return m[1:]
+		}
+		name := m[2 : len(m)-1]
+		if strings.Contains(name, ":") {
+			return m
+		}
+		val, ok := v[name]
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("unresolved variable ${%s}", name)
+			}
+			return m
+		}
+		return val
+	})
+	return res, err
+}
+
+// statementCopier deep copies a statement tree and maps every string on the way.
+// visit is called for every copied pointer, in statement order.
+type statementCopier struct {
+	mapString func(string) (string, error)
+	visit     func(interface{})
+}
+
+func (sc *statementCopier) copyStatement(stmt lexer.Statement) (lexer.Statement, error) {
+	if stmt == nil {
+		return nil, nil
+	}
+	v, err := sc.copyValue(reflect.ValueOf(stmt))
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface().(lexer.Statement), nil
+}
+
+// copyValue returns a deep copy of v with all strings mapped.
+func (sc *statementCopier) copyValue(v reflect.Value) (reflect.Value, error) {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := sc.mapString(v.String())
+		if err != nil {
+			return v, err
+		}
+		return reflect.ValueOf(s).Convert(v.Type()), nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v, nil
+		}
+		c, err := sc.copyValue(v.Elem())
+		if err != nil {
+			if stmt, ok := v.Interface().(lexer.Statement); ok && !isQuery(stmt) {
+				return v, fmt.Errorf("%v in statement %s", err, stmt.String())
+			}
+			return v, err
+		}
+		p := reflect.New(v.Elem().Type())
+		p.Elem().Set(c)
+		if sc.visit != nil {
+			sc.visit(p.Interface())
+		}
+		return p, nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return v, nil
+		}
+		c, err := sc.copyValue(v.Elem())
+		if err != nil {
+			return v, err
+		}
+		i := reflect.New(v.Type()).Elem()
+		i.Set(c)
+		return i, nil
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if !c.Field(i).CanSet() {
+				continue
+			}
+			f, err := sc.copyValue(v.Field(i))
+			if err != nil {
+				return v, err
+			}
+			c.Field(i).Set(f)
+		}
+		return c, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return v, nil
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e, err := sc.copyValue(v.Index(i))
+			if err != nil {
+				return v, err
+			}
+			c.Index(i).Set(e)
+		}
+		return c, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return v, nil
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			e, err := sc.copyValue(v.MapIndex(k))
+			if err != nil {
+				return v, err
+			}
+			c.SetMapIndex(k, e)
+		}
+		return c, nil
+	}
+	return v, nil
+}
+
+//variableResolver interpolates variables into a copy of a statement tree.
+// SET statements are applied in statement order. overrides take precedence over SET statements,
+// which take precedence over the initial variables.
+type variableResolver struct {
+	vars      Variables
+	overrides Variables
+}
+
+func (r *variableResolver) lookup() Variables {
+	merged := make(Variables, len(r.vars)+len(r.overrides))
+	for k, v := range r.vars {
+		merged[k] = v
+	}
+	for k, v := range r.overrides {
+		merged[k] = v
+	}
+	return merged
+}
+
+func (r *variableResolver) resolve(stmt lexer.Statement) (lexer.Statement, error) {
+	sc := &statementCopier{
+		mapString: func(s string) (string, error) {
+			return r.lookup().Interpolate(s)
+		},
+		visit: func(node interface{}) {
+			if set, ok := node.(*lexer.SetStatement); ok {
+				r.vars[set.Name] = set.Value
+			}
+		},
+	}
+	return sc.copyStatement(stmt)
+}
+
+//ResolveVariables returns a copy of stmt with all ${name} references replaced. Variables are looked up
+// in overrides first, then in the SET statements preceding the reference and finally in vars.
+// The original statement is not modified.
+func ResolveVariables(stmt lexer.Statement, vars Variables, overrides Variables) (lexer.Statement, error) {
+	r := &variableResolver{
+		vars:      make(Variables, len(vars)),
+		overrides: overrides,
+	}
+	for k, v := range vars {
+		r.vars[k] = v
+	}
+	return r.resolve(stmt)
+}
+
+func isQuery(stmt lexer.Statement) bool {
+	_, ok := stmt.(*lexer.Query)
+	return ok
+}
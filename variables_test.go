@@ -0,0 +1,117 @@
+package nestor_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jerminb/nestor"
+	"github.com/jerminb/nestor/lexer"
+	"github.com/jerminb/nestor/testserver"
+)
+
+func TestInterpolate(t *testing.T) {
+	vars := nestor.Variables{"host": "foo.bar", "port": "8080"}
+	tests := []struct {
+		Source   string
+		Expected string
+	}{
+		{"http://${host}:${port}/health", "http://foo.bar:8080/health"},
+		{"no variables", "no variables"},
+		{"$${host}", "${host}"},
+		{"${vault:secret/db#password}", "${vault:secret/db#password}"},
+		// unescaped once secrets are resolved
+		{"$${vault:secret/db#password}", "$${vault:secret/db#password}"},
+	}
+	for _, tc := range tests {
+		res, err := vars.Interpolate(tc.Source)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		if res != tc.Expected {
+			t.Errorf("expected %s. got %s", tc.Expected, res)
+		}
+	}
+	if _, err := vars.Interpolate("${missing}"); err == nil {
+		t.Errorf("expected error. got nil")
+	}
+}
+
+func TestResolveVariables(t *testing.T) {
+	s := `set host = "foo.bar"; set url = "http://${host}/${path}"; (download from "${url}" save to "${dir}/file" &)`
+	q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	original := q.String()
+	resolved, err := nestor.ResolveVariables(q, nestor.Variables{"path": "env", "dir": "/env"}, nestor.Variables{"dir": "/override"})
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	if q.String() != original {
+		t.Fatalf("expected original statement to be unchanged. got %s", q.String())
+	}
+	nested := resolved.(*lexer.Query).Statements[2].(*lexer.Query)
+	dl := nested.Statements[0].(*lexer.DownloadStatement)
+	if dl.URL != "http://foo.bar/env" {
+		t.Errorf("expected http://foo.bar/env. got %s", dl.URL)
+	}
+	if dl.FilePath != "/override/file" {
+		t.Errorf("expected /override/file. got %s", dl.FilePath)
+	}
+	if !dl.IsBackground {
+		t.Errorf("expected background flag to be copied. got false")
+	}
+}
+
+func TestResolveKeywordVariables(t *testing.T) {
+	s := `set status = "foo.bar"; set on = "/env"; download from "http://${status}/f" save to "${on}/f"`
+	q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	resolved, err := nestor.ResolveVariables(q, nil, nil)
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	dl := resolved.(*lexer.Query).Statements[2].(*lexer.DownloadStatement)
+	if dl.URL != "http://foo.bar/f" || dl.FilePath != "/env/f" {
+		t.Fatalf("expected http://foo.bar/f saved to /env/f. got %s %s", dl.URL, dl.FilePath)
+	}
+}
+
+func TestResolveVariablesUnresolved(t *testing.T) {
+	s := `download from "http://foo.bar" save to "${nestor_test_undefined}/file"`
+	q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+	if err != nil {
+		t.Fatalf("expected nil . got %v", err)
+	}
+	_, err = nestor.NewQueryExecutor().ExecuteQuery(q)
+	if err == nil {
+		t.Fatalf("expected error. got nil")
+	}
+	if !strings.Contains(err.Error(), "${nestor_test_undefined}") || !strings.Contains(err.Error(), "DOWNLOAD FROM") {
+		t.Fatalf("expected error naming the variable and statement. got %v", err)
+	}
+}
+
+func TestExecuteQueryWithVariables(t *testing.T) {
+	filename := fmt.Sprintf("/tmp/nestor_tests/%d", time.Now().UnixNano())
+	defer os.Remove(filename)
+	testserver.WithTestServer(t, func(url string) {
+		s := `set file = "/tmp/unused"; download from "${url}" save to "${file}"`
+		q, err := lexer.NewParser(strings.NewReader(s)).ParseQuery()
+		if err != nil {
+			t.Fatalf("expected nil . got %v", err)
+		}
+		_, err = nestor.NewQueryExecutorWithVariables(map[string]string{"url": url, "file": filename}).ExecuteQuery(q)
+		if err != nil {
+			t.Fatalf("expected no error. got %v", err)
+		}
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			t.Fatalf("expected file in %s. got nil", filename)
+		}
+	})
+}
@@ -9,6 +9,10 @@ import (
 
 var (
 	ErrRenewerNotRenewable = errors.New("secret is not renewable")
+	//ErrVaultNotConfigured is returned when a statement needs vault but no VaultService is set
+	ErrVaultNotConfigured = errors.New("vault service is not configured")
+
+	defaultVaultService *VaultService
 )
 
 //VaultService is an abstraction around vault to expose the necessary functionality
@@ -40,7 +44,11 @@ func (vs *VaultService) RenewSelfToken() error {
 	if err != nil {
 		return err
 	}
-	if !selfSecret.Renewable {
+	renewable, err := selfSecret.TokenIsRenewable()
+	if err != nil {
+		return err
+	}
+	if !renewable {
 		return ErrRenewerNotRenewable
 	}
 	_, err = vs.client.Auth().Token().RenewSelf(0)
@@ -50,6 +58,64 @@ func (vs *VaultService) RenewSelfToken() error {
 	return nil
 }
 
+//SetToken replaces the token used by the vault client
+func (vs *VaultService) SetToken(token string) {
+	vs.client.SetToken(token)
+}
+
+//ValidateToken checks that vault accepts token, e.g. that it is neither expired nor revoked.
+// The token used by the vault client is left unchanged.
+func (vs *VaultService) ValidateToken(token string) error {
+	c, err := vs.client.Clone()
+	if err != nil {
+		return err
+	}
+	c.SetToken(token)
+	_, err = c.Auth().Token().LookupSelf()
+	return err
+}
+
+//Certificate is a certificate issued by a vault pki backend
+type Certificate struct {
+	Certificate  string
+	PrivateKey   string
+	IssuingCA    string
+	SerialNumber string
+}
+
+//IssueCertificate issues a new certificate from a vault pki issue path like pki/issue/<role>.
+//data carries the issue parameters such as common_name and ttl.
+func (vs *VaultService) IssueCertificate(path string, data map[string]interface{}) (*Certificate, error) {
+	secret, err := vs.client.Logical().Write(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("no certificate returned from path %s", path)
+	}
+	cert := &Certificate{}
+	fields := map[string]*string{
+		"certificate":   &cert.Certificate,
+		"private_key":   &cert.PrivateKey,
+		"issuing_ca":    &cert.IssuingCA,
+		"serial_number": &cert.SerialNumber,
+	}
+	for name, field := range fields {
+		if v, ok := secret.Data[name].(string); ok {
+			*field = v
+		}
+	}
+	if cert.Certificate == "" {
+		return nil, fmt.Errorf("no certificate returned from path %s", path)
+	}
+	return cert, nil
+}
+
+//SetVaultService sets the vault service used by statements that need vault access
+func SetVaultService(vs *VaultService) {
+	defaultVaultService = vs
+}
+
 //NewVaultService is constructor for VaultService
 func NewVaultService(url string, token string) (*VaultService, error) {
 	cfg := vaultAPI.DefaultConfig()
@@ -18,8 +18,8 @@ func TestVaultConstructorPositive(t *testing.T) {
 }
 
 func TestRenewTokenNotRenewable(t *testing.T) {
-	testserver.WithTestVaultServer(t, func(url string, listner net.Listener, token string) {
-		vs, err := nestor.NewVaultService(url, token)
+	testserver.WithTestVaultRootServer(t, func(url string, listner net.Listener, rootToken string) {
+		vs, err := nestor.NewVaultService(url, createTestToken(t, url, rootToken, false))
 		if err != nil {
 			t.Fatalf("expected nil. got %v", err)
 		}
@@ -29,3 +29,15 @@ func TestRenewTokenNotRenewable(t *testing.T) {
 		}
 	})
 }
+
+func TestRenewToken(t *testing.T) {
+	testserver.WithTestVaultServer(t, func(url string, listner net.Listener, token string) {
+		vs, err := nestor.NewVaultService(url, token)
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		if err := vs.RenewSelfToken(); err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+	})
+}
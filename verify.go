@@ -0,0 +1,196 @@
+package nestor
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+
+	"github.com/cavaliercoder/grab"
+)
+
+const (
+	//ChecksumSHA256 is the algorithm of a SHA256 checksum
+	ChecksumSHA256 string = "SHA256"
+	//ChecksumSHA512 is the algorithm of a SHA512 checksum
+	ChecksumSHA512 string = "SHA512"
+
+	// maxSidecarSize bounds the size of checksum and signature files
+	maxSidecarSize int64 = 64 << 10
+)
+
+var (
+	//ErrBadChecksum is the error returned when a downloaded file does not match its checksum
+	ErrBadChecksum = grab.ErrBadChecksum
+	//ErrBadSignature is the error returned when a downloaded file does not match its signature
+	ErrBadSignature = errors.New("signature mismatch")
+	//ErrSignatureKeyNotConfigured is the error returned when a signature is verified without a public key
+	ErrSignatureKeyNotConfigured = errors.New("signature verification key is not configured")
+)
+
+var signatureKey ed25519.PublicKey
+
+//SetSignatureKey sets the ed25519 public key that downloaded files are verified against
+func SetSignatureKey(key ed25519.PublicKey) {
+	signatureKey = key
+}
+
+//ParseSignatureKey parses an ed25519 public key, either PEM encoded or its 32 bytes encoded as base64 or hex
+func ParseSignatureKey(data []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("found %T. expected an ed25519 public key", key)
+		}
+		return edKey, nil
+	}
+	s := strings.TrimSpace(string(data))
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		if key, err = hex.DecodeString(s); err != nil {
+			return nil, fmt.Errorf("invalid public key: expected PEM, base64 or hex")
+		}
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key size %d. expected %d", len(key), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+//Checksum is the expected digest of a downloaded file. When Sum is empty it is read from the
+// sidecar file at URL, whose first field is the hex digest as written by sha256sum or sha512sum.
+type Checksum struct {
+	Algorithm string
+	Sum       []byte
+	URL       string
+}
+
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumSHA512:
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("found %s. expected %s, %s", algorithm, ChecksumSHA256, ChecksumSHA512)
+}
+
+// parseChecksum decodes the hex digest in the first field of s
+func parseChecksum(s string) ([]byte, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty checksum")
+	}
+	sum, err := hex.DecodeString(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid checksum %s: %v", fields[0], err)
+	}
+	return sum, nil
+}
+
+// parseSignature decodes a raw or base64 encoded ed25519 signature
+func parseSignature(b []byte) ([]byte, error) {
+	if len(b) == ed25519.SignatureSize {
+		return b, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("invalid signature: expected %d bytes, raw or base64", ed25519.SignatureSize)
+	}
+	return sig, nil
+}
+
+// fetchSidecar returns the content of a checksum or signature file. The file is requested with the
+// context of the download it belongs to, and with its headers when it is on the same host, see sameHost.
+func fetchSidecar(client *http.Client, download *http.Request, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(download.Context())
+	if sameHost(download.URL, req.URL) {
+		req.Header = download.Header.Clone()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxSidecarSize))
+}
+
+// sameHost reports whether a and b are on the same host. The headers of a request, credentials
+// included, are only sent along to the urls on the host they were declared for.
+func sameHost(a *neturl.URL, b *neturl.URL) bool {
+	return strings.EqualFold(a.Host, b.Host)
+}
+
+// verifyChecksum checks the content of filename against sum. The file is removed on mismatch as grab
+// does for the files it transfers, see removeDownload.
+func verifyChecksum(filename string, h hash.Hash, sum []byte) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	h.Reset()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		if err := removeDownload(filename); err != nil {
+			return fmt.Errorf("%v: %v", ErrBadChecksum, err)
+		}
+		return ErrBadChecksum
+	}
+	return nil
+}
+
+// verifySignature checks the content of filename against sig. The file is removed on mismatch, see
+// removeDownload.
+func verifySignature(filename string, sig []byte, key ed25519.PublicKey) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, content, sig) {
+		if err := removeDownload(filename); err != nil {
+			return fmt.Errorf("%v: %v", ErrBadSignature, err)
+		}
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// removeDownload removes a downloaded file which failed verification along with its metadata, so the
+// next download is not conditional on the validators of a file which no longer exists
+func removeDownload(filename string) error {
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(filename + metadataSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
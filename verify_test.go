@@ -0,0 +1,219 @@
+package nestor_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jerminb/nestor"
+)
+
+var verifyContent = []byte("nestor verify test content")
+
+// newVerifyServer serves verifyContent at /file along with its checksums and signature
+func newVerifyServer(priv ed25519.PrivateKey) *httptest.Server {
+	sum256 := sha256.Sum256(verifyContent)
+	sum512 := sha512.Sum512(verifyContent)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(verifyContent)
+	})
+	mux.HandleFunc("/file.sha256", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s  file\n", hex.EncodeToString(sum256[:]))
+	})
+	mux.HandleFunc("/file.sha512", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s  file\n", hex.EncodeToString(sum512[:]))
+	})
+	mux.HandleFunc("/file.sig", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(ed25519.Sign(priv, verifyContent))
+	})
+	mux.HandleFunc("/file.sig.b64", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, verifyContent)))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestDownloadVerifyChecksum(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	ts := newVerifyServer(priv)
+	defer ts.Close()
+	sum256 := sha256.Sum256(verifyContent)
+	sum512 := sha512.Sum512(verifyContent)
+	tests := []string{
+		fmt.Sprintf(`verify sha256 "%s"`, hex.EncodeToString(sum256[:])),
+		fmt.Sprintf(`verify sha512 "%s"`, hex.EncodeToString(sum512[:])),
+		fmt.Sprintf(`verify sha256 from "%s/file.sha256"`, ts.URL),
+		fmt.Sprintf(`verify sha512 from "%s/file.sha512"`, ts.URL),
+	}
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	for i, tt := range tests {
+		filename := filepath.Join(dir, fmt.Sprint(i))
+		res, err := executePoll(t, fmt.Sprintf(`download from "%s/file" save to "%s" %s`, ts.URL, filename, tt))
+		if err != nil {
+			t.Fatalf("expected nil for %s. got %v", tt, err)
+		}
+		if p, ok := res.Payload.(*nestor.DownloaderPayload); !ok || p.BytesDownloaded != int64(len(verifyContent)) {
+			t.Fatalf("expected %d bytes downloaded for %s. got %v", len(verifyContent), tt, res.Payload)
+		}
+		if _, err := os.Stat(filename); err != nil {
+			t.Fatalf("expected file in %s. got %v", filename, err)
+		}
+	}
+}
+
+func TestDownloadVerifyChecksumMismatch(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	ts := newVerifyServer(priv)
+	defer ts.Close()
+	sum := sha256.Sum256([]byte("other content"))
+	tests := []string{
+		fmt.Sprintf(`verify sha256 "%s"`, hex.EncodeToString(sum[:])),
+		fmt.Sprintf(`verify sha512 from "%s/file.sha256"`, ts.URL),
+	}
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	for i, tt := range tests {
+		filename := filepath.Join(dir, fmt.Sprint(i))
+		_, err := executePoll(t, fmt.Sprintf(`download from "%s/file" save to "%s" %s`, ts.URL, filename, tt))
+		if err == nil {
+			t.Fatalf("expected error for %s. got nil", tt)
+		}
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be deleted for %s. got %v", filename, tt, err)
+		}
+	}
+	filename := filepath.Join(dir, "mismatch")
+	_, err := executePoll(t, fmt.Sprintf(`download from "%s/file" save to "%s" verify sha256 "%s"`, ts.URL, filename, hex.EncodeToString(sum[:])))
+	if err != nestor.ErrBadChecksum {
+		t.Fatalf("expected %v. got %v", nestor.ErrBadChecksum, err)
+	}
+}
+
+func TestDownloadVerifySignature(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	ts := newVerifyServer(priv)
+	defer ts.Close()
+	defer nestor.SetSignatureKey(nil)
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "unconfigured")
+	s := fmt.Sprintf(`download from "%s/file" save to "%s" verify signature from "%s/file.sig"`, ts.URL, filename, ts.URL)
+	if _, err := executePoll(t, s); err != nestor.ErrSignatureKeyNotConfigured {
+		t.Fatalf("expected %v. got %v", nestor.ErrSignatureKeyNotConfigured, err)
+	}
+
+	nestor.SetSignatureKey(pub)
+	for _, sig := range []string{"file.sig", "file.sig.b64"} {
+		filename := filepath.Join(dir, sig)
+		s := fmt.Sprintf(`download from "%s/file" save to "%s" verify sha256 from "%s/file.sha256" verify signature from "%s/%s"`,
+			ts.URL, filename, ts.URL, ts.URL, sig)
+		if _, err := executePoll(t, s); err != nil {
+			t.Fatalf("expected nil for %s. got %v", sig, err)
+		}
+		if _, err := os.Stat(filename); err != nil {
+			t.Fatalf("expected file in %s. got %v", filename, err)
+		}
+	}
+
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	nestor.SetSignatureKey(other)
+	filename = filepath.Join(dir, "mismatch")
+	s = fmt.Sprintf(`download from "%s/file" save to "%s" verify signature from "%s/file.sig"`, ts.URL, filename, ts.URL)
+	if _, err := executePoll(t, s); err != nestor.ErrBadSignature {
+		t.Fatalf("expected %v. got %v", nestor.ErrBadSignature, err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be deleted. got %v", filename, err)
+	}
+}
+
+func TestDownloadVerifySidecarHeader(t *testing.T) {
+	sum := sha256.Sum256(verifyContent)
+	var mu sync.Mutex
+	auth := map[string]string{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/file" {
+			w.Write(verifyContent)
+			return
+		}
+		mu.Lock()
+		auth[r.Host] = r.Header.Get("Authorization")
+		mu.Unlock()
+		fmt.Fprintf(w, "%s  file\n", hex.EncodeToString(sum[:]))
+	})
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	other := httptest.NewServer(handler)
+	defer other.Close()
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		server   *httptest.Server
+		expected string
+	}{
+		{ts, "Bearer token"},
+		{other, ""},
+	}
+	for i, tt := range tests {
+		_, err := nestor.NewDownloader().Execute(context.Background(), nestor.DownloaderParameters{
+			FilePath: filepath.Join(dir, fmt.Sprint(i)),
+			URL:      ts.URL + "/file",
+			Header:   http.Header{"Authorization": {"Bearer token"}},
+			Checksum: &nestor.Checksum{Algorithm: nestor.ChecksumSHA256, URL: tt.server.URL + "/file.sha256"},
+		})
+		if err != nil {
+			t.Fatalf("expected nil. got %v", err)
+		}
+		host := strings.TrimPrefix(tt.server.URL, "http://")
+		mu.Lock()
+		got, ok := auth[host]
+		mu.Unlock()
+		if !ok || got != tt.expected {
+			t.Fatalf("expected Authorization %q sent to %s. got %q", tt.expected, host, got)
+		}
+	}
+}
+
+func TestParseSignatureKey(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("expected nil. got %v", err)
+	}
+	tests := [][]byte{
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+		[]byte(base64.StdEncoding.EncodeToString(pub) + "\n"),
+		[]byte(hex.EncodeToString(pub)),
+	}
+	for _, tt := range tests {
+		key, err := nestor.ParseSignatureKey(tt)
+		if err != nil {
+			t.Fatalf("expected nil for %s. got %v", tt, err)
+		}
+		if !bytes.Equal(key, pub) {
+			t.Fatalf("expected %x. got %x", pub, key)
+		}
+	}
+	for _, tt := range []string{"", "foo", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := nestor.ParseSignatureKey([]byte(tt)); err == nil {
+			t.Fatalf("expected error for %q. got nil", tt)
+		}
+	}
+}